@@ -0,0 +1,52 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+type authServerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	PAREndpoint           string `json:"pushed_authorization_request_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	RevocationEndpoint    string `json:"revocation_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	// RFC 9207 section 3
+	ISSParameterSupported bool `json:"authorization_response_iss_parameter_supported"`
+}
+
+// metadata fetches the discovery document of the authorization server with
+// the given issuer on first use and caches it for the lifetime of the handler.
+func (h *Handler) metadata(issuer string) (*authServerMetadata, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if md, ok := h.md[issuer]; ok {
+		return md, nil
+	}
+
+	res, err := h.httpClient.Get(issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery endpoint returned %d", res.StatusCode)
+	}
+
+	var md authServerMetadata
+	if err := json.NewDecoder(res.Body).Decode(&md); err != nil {
+		return nil, err
+	}
+
+	// RFC 8414 section 3.3
+	if md.Issuer != issuer {
+		return nil, fmt.Errorf("issuer mismatch: expected %s, got %s", issuer, md.Issuer)
+	}
+
+	h.md[issuer] = &md
+	return &md, nil
+}
@@ -1,22 +1,30 @@
 package client
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/labstack/echo/v4"
+	"github.com/voice0726/oauth-playground/dpop"
+	"go.step.sm/crypto/jose"
 	"go.step.sm/crypto/randutil"
 	"go.uber.org/zap"
 )
 
-var authServer = struct {
-	authEndpoint  string
-	tokenEndpoint string
-}{authEndpoint: "http://localhost:9091/authorize", tokenEndpoint: "http://localhost:9091/token"}
+// authServers are the issuers of the authorization servers the client is
+// registered with, under the same credentials. The first one is used unless
+// the user picks another, which lets the playground demonstrate mix-up attacks
+// between them (RFC 9207).
+var authServers = []string{"http://localhost:9091", "http://localhost:9092"}
 
 var client = struct {
 	redirectURIs []string
@@ -27,11 +35,20 @@ var client = struct {
 type Handler struct {
 	httpClient *http.Client
 	logger     *zap.Logger
+	// key that DPoP-bound tokens are bound to; generated on start-up
+	dpopKey *jose.JSONWebKey
+
+	mu sync.Mutex
+	md map[string]*authServerMetadata
 }
 
 func NewHandler(logger *zap.Logger) (*Handler, error) {
 	h := &http.Client{}
-	return &Handler{httpClient: h, logger: logger}, nil
+	dpopKey, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	if err != nil {
+		return nil, err
+	}
+	return &Handler{httpClient: h, logger: logger, dpopKey: dpopKey, md: map[string]*authServerMetadata{}}, nil
 }
 
 func (h *Handler) HandleIndex(c echo.Context) error {
@@ -39,19 +56,82 @@ func (h *Handler) HandleIndex(c echo.Context) error {
 }
 
 func (h *Handler) HandleAuthorize(c echo.Context) error {
-	u, _ := url.Parse(authServer.authEndpoint)
+	// ?as=1 picks the second authorization server
+	issuer := authServers[0]
+	if i, err := strconv.Atoi(c.QueryParam("as")); err == nil && i >= 0 && i < len(authServers) {
+		issuer = authServers[i]
+	}
+	// ?mixup=1 plays a mix-up attack (RFC 9207 section 1): the picked server
+	// is malicious and sends the user on to another server, where the client
+	// is registered too. The client still expects the response from the
+	// server it picked, and would redeem the other server's code there.
+	target := issuer
+	if c.QueryParam("mixup") != "" {
+		target = authServers[0]
+		if issuer == authServers[0] {
+			target = authServers[1]
+		}
+	}
+	md, err := h.metadata(target)
+	if err != nil {
+		h.logger.Error("failed to discover authorization server", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, "authorization request failed")
+	}
+	u, _ := url.Parse(md.AuthorizationEndpoint)
 	q := u.Query()
 	q.Add("response_type", "code")
 	q.Add("client_id", client.clientID)
 	q.Add("redirect_uri", client.redirectURIs[0])
-	q.Add("scope", "")
+	q.Add("scope", "openid profile email")
 	state, err := randutil.Alphanumeric(32)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, "authorization request failed")
 	}
 	q.Add("state", state)
+	nonce, err := randutil.Alphanumeric(32)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "authorization request failed")
+	}
+	q.Add("nonce", nonce)
+	verifier, err := randutil.Alphanumeric(64)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "authorization request failed")
+	}
+	q.Add("code_challenge", codeChallengeS256(verifier))
+	q.Add("code_challenge_method", "S256")
+	if c.QueryParam("jarm") != "" {
+		q.Add("response_mode", "jwt")
+	}
+
+	// ?par=1 pushes the parameters first and only sends the request_uri
+	// through the browser (RFC 9126)
+	if c.QueryParam("par") != "" && md.PAREndpoint != "" {
+		requestURI, err := h.pushAuthorizationRequest(target, q)
+		if err != nil {
+			h.logger.Error("pushed authorization request failed", zap.Error(err))
+			return c.JSON(http.StatusInternalServerError, "authorization request failed")
+		}
+		q = url.Values{}
+		q.Add("client_id", client.clientID)
+		q.Add("request_uri", requestURI)
+	}
 	u.RawQuery = q.Encode()
+	c.SetCookie(&http.Cookie{Name: "issuer", Value: issuer, HttpOnly: true})
 	c.SetCookie(&http.Cookie{Name: "state", Value: state, HttpOnly: true})
+	c.SetCookie(&http.Cookie{Name: "code_verifier", Value: verifier, HttpOnly: true})
+	c.SetCookie(&http.Cookie{Name: "nonce", Value: nonce, HttpOnly: true})
+	// ?jarm=1 asks for the response in a signed JWT (JARM)
+	if c.QueryParam("jarm") != "" {
+		c.SetCookie(&http.Cookie{Name: "jarm", Value: "1", HttpOnly: true})
+	} else {
+		c.SetCookie(&http.Cookie{Name: "jarm", MaxAge: -1, HttpOnly: true})
+	}
+	// ?dpop=1 asks for tokens bound to the client's DPoP key (RFC 9449)
+	if c.QueryParam("dpop") != "" {
+		c.SetCookie(&http.Cookie{Name: "dpop", Value: "1", HttpOnly: true})
+	} else {
+		c.SetCookie(&http.Cookie{Name: "dpop", MaxAge: -1, HttpOnly: true})
+	}
 	return c.Redirect(http.StatusSeeOther, u.String())
 }
 
@@ -60,7 +140,31 @@ func (h *Handler) HandleCallback(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, "failed to parse cookie")
 	}
+	issuer := authServerIssuer(c)
 	q := c.Request().URL.Query()
+	// a client that asked for a signed response must not accept a plain one,
+	// or an attacker could simply leave the signature out
+	if _, err := c.Request().Cookie("jarm"); err == nil {
+		q, err = h.verifyAuthorizationResponse(issuer, q.Get("response"))
+		if err != nil {
+			h.logger.Info("invalid authorization response", zap.Error(err))
+			return c.JSON(http.StatusBadRequest, "invalid authorization response")
+		}
+	}
+
+	md, err := h.metadata(issuer)
+	if err != nil {
+		h.logger.Error("failed to discover authorization server", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, "authorization request failed")
+	}
+	// the response must come from the server the flow was started with, or
+	// its code would be sent to the wrong token endpoint (RFC 9207 section 2.4)
+	if iss := q.Get("iss"); iss != "" || md.ISSParameterSupported {
+		if iss != issuer {
+			h.logger.Warn("authorization response from an unexpected issuer, possible mix-up attack", zap.String("expected", issuer), zap.String("iss", iss))
+			return c.JSON(http.StatusBadRequest, "issuer not match")
+		}
+	}
 	if stateCookie.Value != q.Get("state") {
 		return c.JSON(http.StatusBadRequest, "state not match")
 	}
@@ -71,45 +175,239 @@ func (h *Handler) HandleCallback(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, "invalid code")
 	}
 
+	verifierCookie, err := c.Request().Cookie("code_verifier")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "failed to parse cookie")
+	}
+
 	body := url.Values{}
 	body.Add("grant_type", "authorization_code")
 	body.Add("code", code)
 	body.Add("redirect_uri", client.redirectURIs[0])
+	body.Add("code_verifier", verifierCookie.Value)
 
-	req, err := http.NewRequest("POST", "http://localhost:9091/token", strings.NewReader(body.Encode()))
+	_, err = c.Request().Cookie("dpop")
+	resBody, err := h.requestToken(issuer, body, err == nil)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, "failed to create request")
+		h.logger.Error("token request failed", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, "authorization request failed")
+	}
+
+	if resBody.IDToken != "" {
+		nonceCookie, err := c.Request().Cookie("nonce")
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "failed to parse cookie")
+		}
+		claims, err := h.verifyIDToken(issuer, resBody.IDToken, nonceCookie.Value)
+		if err != nil {
+			h.logger.Info("invalid id token", zap.Error(err))
+			return c.JSON(http.StatusBadRequest, "invalid id token")
+		}
+		h.logger.Debug("logged in", zap.String("sub", claims.Subject))
+	}
+
+	setTokenCookies(c, resBody)
+	return c.JSON(http.StatusOK, "ok")
+}
+
+func (h *Handler) HandleUserinfo(c echo.Context) error {
+	cookie, err := c.Request().Cookie("access_token")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "no access token")
+	}
+
+	b, err := h.fetchUserinfo(authServerIssuer(c), cookie.Value, isDPoPBound(c))
+	if err != nil {
+		h.logger.Error("userinfo request failed", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, "userinfo request failed")
+	}
+	return c.JSONBlob(http.StatusOK, b)
+}
+
+func (h *Handler) HandleRefresh(c echo.Context) error {
+	refreshCookie, err := c.Request().Cookie("refresh_token")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "no refresh token")
+	}
+
+	body := url.Values{}
+	body.Add("grant_type", "refresh_token")
+	body.Add("refresh_token", refreshCookie.Value)
+
+	resBody, err := h.requestToken(authServerIssuer(c), body, isDPoPBound(c))
+	if err != nil {
+		h.logger.Error("refresh request failed", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, "refresh request failed")
+	}
+
+	setTokenCookies(c, resBody)
+	return c.JSON(http.StatusOK, "ok")
+}
+
+func (h *Handler) HandleLogout(c echo.Context) error {
+	issuer := authServerIssuer(c)
+	if cookie, err := c.Request().Cookie("refresh_token"); err == nil {
+		if err := h.revokeToken(issuer, cookie.Value, "refresh_token"); err != nil {
+			h.logger.Error("failed to revoke refresh token", zap.Error(err))
+		}
+	}
+	if cookie, err := c.Request().Cookie("access_token"); err == nil {
+		if err := h.revokeToken(issuer, cookie.Value, "access_token"); err != nil {
+			h.logger.Error("failed to revoke access token", zap.Error(err))
+		}
+	}
+
+	c.SetCookie(&http.Cookie{Name: "access_token", MaxAge: -1, HttpOnly: true})
+	c.SetCookie(&http.Cookie{Name: "refresh_token", MaxAge: -1, HttpOnly: true})
+	c.SetCookie(&http.Cookie{Name: "token_type", MaxAge: -1, HttpOnly: true})
+	return c.Redirect(http.StatusSeeOther, "/")
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	IDToken      string `json:"id_token"`
+	Scope        string `json:"scope"`
+}
+
+func (h *Handler) requestToken(issuer string, body url.Values, useDPoP bool) (*tokenResponse, error) {
+	md, err := h.metadata(issuer)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", md.TokenEndpoint, strings.NewReader(body.Encode()))
+	if err != nil {
+		return nil, err
 	}
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Add("Authorization", "Basic "+encodeClientCredential(client.clientID, client.clientSecret))
+	if useDPoP {
+		proof, err := dpop.NewProof(h.dpopKey, req.Method, md.TokenEndpoint, "")
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add(dpop.HeaderName, proof)
+	}
 
 	res, err := h.httpClient.Do(req)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, "authorization request failed")
+		return nil, err
 	}
 	defer res.Body.Close()
 
 	b, err := io.ReadAll(res.Body)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, "authorization request failed")
+		return nil, err
+	}
+	// the body carries the tokens, so it is not logged
+	h.logger.Debug("response from token endpoint", zap.Int("status", res.StatusCode))
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %d: %s", res.StatusCode, b)
+	}
+
+	var resBody tokenResponse
+	if err := json.Unmarshal(b, &resBody); err != nil {
+		return nil, err
+	}
+	return &resBody, nil
+}
+
+func (h *Handler) pushAuthorizationRequest(issuer string, body url.Values) (string, error) {
+	md, err := h.metadata(issuer)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", md.PAREndpoint, strings.NewReader(body.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Authorization", "Basic "+encodeClientCredential(client.clientID, client.clientSecret))
+
+	res, err := h.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("par endpoint returned %d: %s", res.StatusCode, b)
 	}
-	h.logger.Debug("response from token endpoint", zap.ByteString("body", b))
 
 	var resBody struct {
-		AccessToken string `json:"access_token"`
-		TokenType   string `json:"token_type"`
-		Scope       string `json:"scope"`
+		RequestURI string `json:"request_uri"`
 	}
-	err = json.Unmarshal(b, &resBody)
+	if err := json.Unmarshal(b, &resBody); err != nil {
+		return "", err
+	}
+	return resBody.RequestURI, nil
+}
+
+func (h *Handler) revokeToken(issuer, token, hint string) error {
+	body := url.Values{}
+	body.Add("token", token)
+	body.Add("token_type_hint", hint)
+
+	md, err := h.metadata(issuer)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, "authorization request failed")
+		return err
 	}
+	req, err := http.NewRequest("POST", md.RevocationEndpoint, strings.NewReader(body.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Authorization", "Basic "+encodeClientCredential(client.clientID, client.clientSecret))
 
-	cookie := http.Cookie{Name: "access_token", Value: resBody.AccessToken, HttpOnly: true}
-	c.SetCookie(&cookie)
-	return c.JSON(http.StatusOK, "ok")
+	res, err := h.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("revocation endpoint returned %d", res.StatusCode)
+	}
+	return nil
+}
+
+func setTokenCookies(c echo.Context, res *tokenResponse) {
+	c.SetCookie(&http.Cookie{Name: "access_token", Value: res.AccessToken, HttpOnly: true})
+	c.SetCookie(&http.Cookie{Name: "token_type", Value: res.TokenType, HttpOnly: true})
+	if res.RefreshToken != "" {
+		c.SetCookie(&http.Cookie{Name: "refresh_token", Value: res.RefreshToken, HttpOnly: true})
+	}
 }
 
+// authServerIssuer returns the issuer of the authorization server the flow was
+// started with.
+func authServerIssuer(c echo.Context) string {
+	cookie, err := c.Request().Cookie("issuer")
+	if err == nil && slices.Contains(authServers, cookie.Value) {
+		return cookie.Value
+	}
+	return authServers[0]
+}
+
+func isDPoPBound(c echo.Context) bool {
+	cookie, err := c.Request().Cookie("token_type")
+	return err == nil && cookie.Value == dpop.HeaderName
+}
+
+// encodeClientCredential encodes client_secret_basic credentials, which are
+// form-urlencoded first (RFC 6749 section 2.3.1).
 func encodeClientCredential(id, secret string) string {
-	return base64.URLEncoding.EncodeToString([]byte(id + ":" + secret))
+	return base64.StdEncoding.EncodeToString([]byte(url.QueryEscape(id) + ":" + url.QueryEscape(secret)))
+}
+
+func codeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
 }
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"errors"
+	"net/url"
+	"time"
+
+	"go.step.sm/crypto/jose"
+)
+
+// verifyAuthorizationResponse checks a JWT-secured authorization response
+// (JARM section 2.4) and returns the authorization response parameters it
+// carries.
+func (h *Handler) verifyAuthorizationResponse(issuer, raw string) (url.Values, error) {
+	if raw == "" {
+		return nil, errors.New("response parameter missing")
+	}
+
+	var claims jose.Claims
+	var params map[string]interface{}
+	if err := h.verifyJWT(issuer, raw, &claims, &params); err != nil {
+		return nil, err
+	}
+
+	expected := jose.Expected{
+		Issuer:   issuer,
+		Audience: jose.Audience{client.clientID},
+		Time:     time.Now(),
+	}
+	if err := claims.ValidateWithLeeway(expected, time.Minute); err != nil {
+		return nil, err
+	}
+	if claims.Expiry == nil {
+		return nil, errors.New("exp missing")
+	}
+
+	q := url.Values{}
+	for k, v := range params {
+		if s, ok := v.(string); ok {
+			q.Set(k, s)
+		}
+	}
+	return q, nil
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/voice0726/oauth-playground/dpop"
+	"go.step.sm/crypto/jose"
+)
+
+type idTokenClaims struct {
+	jose.Claims
+	Nonce string `json:"nonce"`
+}
+
+func (h *Handler) fetchJWKS(issuer string) (*jose.JSONWebKeySet, error) {
+	md, err := h.metadata(issuer)
+	if err != nil {
+		return nil, err
+	}
+	res, err := h.httpClient.Get(md.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks endpoint returned %d", res.StatusCode)
+	}
+
+	var keys jose.JSONWebKeySet
+	if err := json.NewDecoder(res.Body).Decode(&keys); err != nil {
+		return nil, err
+	}
+	return &keys, nil
+}
+
+// verifyJWT checks the signature of raw against the keys published by the
+// authorization server with the given issuer and decodes its claims into dest.
+func (h *Handler) verifyJWT(issuer, raw string, dest ...interface{}) error {
+	tok, err := jose.ParseSigned(raw)
+	if err != nil {
+		return err
+	}
+	if len(tok.Headers) != 1 {
+		return errors.New("unexpected number of signatures")
+	}
+
+	keys, err := h.fetchJWKS(issuer)
+	if err != nil {
+		return err
+	}
+	candidates := keys.Key(tok.Headers[0].KeyID)
+	if len(candidates) == 0 {
+		return errors.New("signing key not found")
+	}
+
+	return jose.Verify(tok, candidates[0].Key, dest...)
+}
+
+func (h *Handler) verifyIDToken(issuer, raw, nonce string) (*idTokenClaims, error) {
+	var claims idTokenClaims
+	if err := h.verifyJWT(issuer, raw, &claims); err != nil {
+		return nil, err
+	}
+
+	expected := jose.Expected{
+		Issuer:   issuer,
+		Audience: jose.Audience{client.clientID},
+		Time:     time.Now(),
+	}
+	if err := claims.ValidateWithLeeway(expected, time.Minute); err != nil {
+		return nil, err
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("nonce not match")
+	}
+	return &claims, nil
+}
+
+func (h *Handler) fetchUserinfo(issuer, accessToken string, dpopBound bool) ([]byte, error) {
+	md, err := h.metadata(issuer)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", md.UserinfoEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if dpopBound {
+		proof, err := dpop.NewProof(h.dpopKey, req.Method, md.UserinfoEndpoint, accessToken)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Authorization", "DPoP "+accessToken)
+		req.Header.Add(dpop.HeaderName, proof)
+	} else {
+		req.Header.Add("Authorization", "Bearer "+accessToken)
+	}
+
+	res, err := h.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("userinfo endpoint returned %d: %s", res.StatusCode, b)
+	}
+	return b, nil
+}
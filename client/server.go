@@ -40,14 +40,17 @@ func NewServer(logger *zap.Logger) (*Server, error) {
 		},
 	}))
 
-	initRoute(e, *h)
+	initRoute(e, h)
 	return &Server{e: e, lg: logger}, nil
 }
 
-func initRoute(e *echo.Echo, h Handler) {
+func initRoute(e *echo.Echo, h *Handler) {
 	e.GET("/", h.HandleIndex)
 	e.GET("/authorize", h.HandleAuthorize)
 	e.GET("/callback", h.HandleCallback)
+	e.GET("/refresh", h.HandleRefresh)
+	e.GET("/userinfo", h.HandleUserinfo)
+	e.GET("/logout", h.HandleLogout)
 }
 
 func (s *Server) Start(address string) error {
@@ -0,0 +1,191 @@
+// Package dpop creates and verifies DPoP proofs (RFC 9449), the JWTs a client
+// sends to prove possession of the key its tokens are bound to.
+package dpop
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"go.step.sm/crypto/jose"
+)
+
+const (
+	HeaderName = "DPoP"
+	ProofType  = "dpop+jwt"
+	// MaxAge is how far the iat of a proof may be from the current time.
+	// Proofs only need to be remembered for replay detection this long.
+	MaxAge = 5 * time.Minute
+)
+
+// SigningAlgs are the asymmetric algorithms accepted on proofs.
+var SigningAlgs = []string{jose.ES256, jose.ES384, jose.ES512, jose.RS256, jose.PS256, jose.EdDSA}
+
+var ErrInvalidProof = errors.New("invalid DPoP proof")
+
+// Proof is a verified DPoP proof.
+type Proof struct {
+	JTI      string
+	IssuedAt time.Time
+	// JKT is the JWK SHA-256 thumbprint of the key that signed the proof,
+	// which is what tokens are bound to (cnf.jkt).
+	JKT string
+}
+
+type claims struct {
+	jose.Claims
+	HTM string `json:"htm"`
+	HTU string `json:"htu"`
+	ATH string `json:"ath,omitempty"`
+}
+
+// NewProof signs a proof for a request with method to uri. accessToken is
+// set when the proof accompanies a bound access token.
+func NewProof(key *jose.JSONWebKey, method, uri, accessToken string) (string, error) {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.SignatureAlgorithm(key.Algorithm), Key: key},
+		(&jose.SignerOptions{EmbedJWK: true}).WithType(ProofType),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	c := claims{
+		Claims: jose.Claims{
+			ID:       uuid.NewString(),
+			IssuedAt: jose.NewNumericDate(time.Now()),
+		},
+		HTM: method,
+		HTU: uri,
+	}
+	if accessToken != "" {
+		c.ATH = AccessTokenHash(accessToken)
+	}
+	return jose.Signed(signer).Claims(c).CompactSerialize()
+}
+
+// Verify checks a proof for a request with method to uri. When accessToken is
+// not empty the proof must carry its hash in ath (RFC 9449 section 4.3).
+// Replay detection is left to the caller, which should remember Proof.JTI
+// for MaxAge.
+func Verify(proof, method, uri, accessToken string) (*Proof, error) {
+	tok, err := jose.ParseSigned(proof)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidProof, err)
+	}
+	if len(tok.Headers) != 1 {
+		return nil, fmt.Errorf("%w: unexpected number of signatures", ErrInvalidProof)
+	}
+	header := tok.Headers[0]
+	if typ, _ := header.ExtraHeaders["typ"].(string); typ != ProofType {
+		return nil, fmt.Errorf("%w: typ must be %s", ErrInvalidProof, ProofType)
+	}
+	if !slices.Contains(SigningAlgs, header.Algorithm) {
+		return nil, fmt.Errorf("%w: unsupported signing algorithm %q", ErrInvalidProof, header.Algorithm)
+	}
+	if header.JSONWebKey == nil || !header.JSONWebKey.IsPublic() {
+		return nil, fmt.Errorf("%w: jwk must be a public key", ErrInvalidProof)
+	}
+
+	var c claims
+	if err := jose.Verify(tok, header.JSONWebKey.Key, &c); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidProof, err)
+	}
+
+	if c.ID == "" || c.IssuedAt == nil {
+		return nil, fmt.Errorf("%w: jti and iat are required", ErrInvalidProof)
+	}
+	iat := c.IssuedAt.Time()
+	if d := time.Since(iat); d > MaxAge || d < -MaxAge {
+		return nil, fmt.Errorf("%w: iat is out of range", ErrInvalidProof)
+	}
+	if c.HTM != method {
+		return nil, fmt.Errorf("%w: htm does not match", ErrInvalidProof)
+	}
+	if normalizeURI(c.HTU) != normalizeURI(uri) {
+		return nil, fmt.Errorf("%w: htu does not match", ErrInvalidProof)
+	}
+	if accessToken != "" && c.ATH != AccessTokenHash(accessToken) {
+		return nil, fmt.Errorf("%w: ath does not match the access token", ErrInvalidProof)
+	}
+
+	jkt, err := Thumbprint(header.JSONWebKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidProof, err)
+	}
+	return &Proof{JTI: c.ID, IssuedAt: iat, JKT: jkt}, nil
+}
+
+// VerifyRequest is the resource server side check: r must carry exactly one
+// proof for itself, bound to accessToken and signed by the key the token was
+// issued for. The proof must be for baseURL, the configured origin of the
+// resource, rather than the Host header the client chose.
+func VerifyRequest(r *http.Request, baseURL, accessToken, jkt string) (*Proof, error) {
+	proofs := r.Header.Values(HeaderName)
+	if len(proofs) != 1 {
+		return nil, fmt.Errorf("%w: exactly one DPoP header is required", ErrInvalidProof)
+	}
+
+	proof, err := Verify(proofs[0], r.Method, baseURL+r.URL.Path, accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if proof.JKT != jkt {
+		return nil, fmt.Errorf("%w: the proof was signed by another key than the token is bound to", ErrInvalidProof)
+	}
+	return proof, nil
+}
+
+// AccessToken returns the token of the Authorization header and whether it
+// was presented with the DPoP scheme rather than as a bearer token.
+func AccessToken(r *http.Request) (token string, bound bool) {
+	auth := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(auth, "DPoP "); ok {
+		return token, true
+	}
+	if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+		return token, false
+	}
+	return "", false
+}
+
+// Thumbprint returns the base64url encoded JWK SHA-256 thumbprint of key
+// (RFC 7638).
+func Thumbprint(key *jose.JSONWebKey) (string, error) {
+	b, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func AccessTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// normalizeURI drops the query and fragment, which htu does not cover, and
+// the parts of the URI that compare case-insensitively (RFC 9449 section
+// 4.3).
+func normalizeURI(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "http" && u.Port() == "80") || (u.Scheme == "https" && u.Port() == "443") {
+		u.Host = u.Hostname()
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
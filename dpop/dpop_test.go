@@ -0,0 +1,178 @@
+package dpop
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.step.sm/crypto/jose"
+)
+
+const (
+	testURI         = "https://as.example/token"
+	testAccessToken = "access-token"
+)
+
+func generateKey(t *testing.T) *jose.JSONWebKey {
+	t.Helper()
+	key, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// signProof signs c with key, embedding jwk in the header, so that tests can
+// produce proofs NewProof never would.
+func signProof(t *testing.T, key *jose.JSONWebKey, typ jose.ContentType, jwk interface{}, c claims) string {
+	t.Helper()
+	opts := (&jose.SignerOptions{}).WithType(typ).WithHeader("jwk", jwk)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := jose.Signed(signer).Claims(c).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+func validClaims() claims {
+	return claims{
+		Claims: jose.Claims{
+			ID:       "jti",
+			IssuedAt: jose.NewNumericDate(time.Now()),
+		},
+		HTM: "POST",
+		HTU: testURI,
+		ATH: AccessTokenHash(testAccessToken),
+	}
+}
+
+func TestVerify(t *testing.T) {
+	key := generateKey(t)
+	symmetric := jose.JSONWebKey{Key: []byte("0123456789abcdef0123456789abcdef"), Algorithm: "HS256"}
+
+	tests := []struct {
+		name    string
+		typ     jose.ContentType
+		jwk     interface{}
+		modify  func(c *claims)
+		uri     string
+		wantErr bool
+	}{
+		{name: "valid"},
+		{name: "htu with default port and query", modify: func(c *claims) { c.HTU = "https://AS.example:443/token?foo=bar#frag" }},
+		{name: "uri with default port", uri: "https://as.example:443/token"},
+		{name: "wrong htm", modify: func(c *claims) { c.HTM = "GET" }, wantErr: true},
+		{name: "htu with another path", modify: func(c *claims) { c.HTU = "https://as.example/userinfo" }, wantErr: true},
+		{name: "htu with another port", modify: func(c *claims) { c.HTU = "https://as.example:8443/token" }, wantErr: true},
+		{name: "htu with another scheme", modify: func(c *claims) { c.HTU = "http://as.example/token" }, wantErr: true},
+		{name: "htu with the default port of another scheme", modify: func(c *claims) { c.HTU = "https://as.example:80/token" }, wantErr: true},
+		{name: "wrong ath", modify: func(c *claims) { c.ATH = AccessTokenHash("another-token") }, wantErr: true},
+		{name: "missing ath", modify: func(c *claims) { c.ATH = "" }, wantErr: true},
+		{name: "stale iat", modify: func(c *claims) { c.IssuedAt = jose.NewNumericDate(time.Now().Add(-MaxAge - time.Minute)) }, wantErr: true},
+		{name: "future iat", modify: func(c *claims) { c.IssuedAt = jose.NewNumericDate(time.Now().Add(MaxAge + time.Minute)) }, wantErr: true},
+		{name: "missing jti", modify: func(c *claims) { c.ID = "" }, wantErr: true},
+		{name: "wrong typ", typ: "JWT", wantErr: true},
+		{name: "symmetric jwk", jwk: symmetric, wantErr: true},
+		{name: "private jwk", jwk: key, wantErr: true},
+		{name: "jwk of another key", jwk: generateKey(t).Public(), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			typ := tt.typ
+			if typ == "" {
+				typ = ProofType
+			}
+			jwk := tt.jwk
+			if jwk == nil {
+				jwk = key.Public()
+			}
+			c := validClaims()
+			if tt.modify != nil {
+				tt.modify(&c)
+			}
+			uri := tt.uri
+			if uri == "" {
+				uri = testURI
+			}
+
+			proof, err := Verify(signProof(t, key, typ, jwk, c), "POST", uri, testAccessToken)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidProof) {
+					t.Fatalf("Verify() error = %v, want ErrInvalidProof", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			want, _ := Thumbprint(&jose.JSONWebKey{Key: key.Public().Key})
+			if proof.JKT != want {
+				t.Errorf("JKT = %q, want %q", proof.JKT, want)
+			}
+		})
+	}
+}
+
+func TestNewProofVerifies(t *testing.T) {
+	key := generateKey(t)
+	proof, err := NewProof(key, "GET", "https://rs.example/resource", testAccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(proof, "GET", "https://rs.example/resource", testAccessToken); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+}
+
+func TestVerifyRequest(t *testing.T) {
+	key := generateKey(t)
+	jkt, err := Thumbprint(&jose.JSONWebKey{Key: key.Public().Key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		host    string
+		htu     string
+		jkt     string
+		proofs  int
+		wantErr bool
+	}{
+		{name: "valid", host: "rs.example", htu: "https://rs.example/resource", jkt: jkt, proofs: 1},
+		{name: "proof for the Host header", host: "attacker.example", htu: "https://attacker.example/resource", jkt: jkt, proofs: 1, wantErr: true},
+		{name: "Host header is ignored", host: "attacker.example", htu: "https://rs.example/resource", jkt: jkt, proofs: 1},
+		{name: "another key", host: "rs.example", htu: "https://rs.example/resource", jkt: "another", proofs: 1, wantErr: true},
+		{name: "no proof", host: "rs.example", htu: "https://rs.example/resource", jkt: jkt, wantErr: true},
+		{name: "two proofs", host: "rs.example", htu: "https://rs.example/resource", jkt: jkt, proofs: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://"+tt.host+"/resource", nil)
+			for i := 0; i < tt.proofs; i++ {
+				proof, err := NewProof(key, http.MethodGet, tt.htu, testAccessToken)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r.Header.Add(HeaderName, proof)
+			}
+
+			_, err := VerifyRequest(r, "https://rs.example", testAccessToken, tt.jkt)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidProof) {
+					t.Fatalf("VerifyRequest() error = %v, want ErrInvalidProof", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyRequest() error = %v", err)
+			}
+		})
+	}
+}
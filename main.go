@@ -1,7 +1,9 @@
 package main
 
 import (
+	"crypto/x509"
 	"log"
+	"os"
 	"sync"
 
 	"github.com/voice0726/oauth-playground/client"
@@ -18,7 +20,30 @@ func main() {
 		log.Fatal(err)
 	}
 	lg, _ := zap.NewDevelopment()
-	s, err := server.NewServer(lg)
+	// client certificates issued by the CA in client-ca.pem, if it exists, can
+	// be used for tls_client_auth
+	clientCAs := x509.NewCertPool()
+	if pem, err := os.ReadFile("client-ca.pem"); err == nil {
+		clientCAs.AppendCertsFromPEM(pem)
+	}
+	mtls := &server.MTLSConfig{BaseURL: "https://localhost:9443", ClientCAs: clientCAs}
+	// what clients may do beyond their registered metadata, such as the
+	// issuers they trust for the jwt-bearer grant, is up to the operator
+	var policies map[string]server.ClientPolicy
+	if _, err := os.Stat("client-policies.json"); err == nil {
+		policies, err = server.LoadClientPolicies("client-policies.json")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	s, err := server.NewServer("http://localhost:9091", mtls, policies, lg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// a second authorization server on the same database, so that the client
+	// can demonstrate mix-up attacks between the two
+	s2, err := server.NewServer("http://localhost:9092", nil, nil, lg)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -29,7 +54,7 @@ func main() {
 	}
 
 	var wg sync.WaitGroup
-	wg.Add(2)
+	wg.Add(4)
 	go func() {
 		defer wg.Done()
 		log.Fatal(c.Start(":9090"))
@@ -38,6 +63,14 @@ func main() {
 		defer wg.Done()
 		log.Fatal(s.Start(":9091"))
 	}()
+	go func() {
+		defer wg.Done()
+		log.Fatal(s.StartTLS(":9443"))
+	}()
+	go func() {
+		defer wg.Done()
+		log.Fatal(s2.Start(":9092"))
+	}()
 
 	wg.Wait()
 }
@@ -48,5 +81,5 @@ func migrate() error {
 		return err
 	}
 
-	return db.AutoMigrate(&model.AuthCode{}, &model.Client{}, &model.AuthRequest{}, &model.Token{})
+	return db.AutoMigrate(&model.AuthCode{}, &model.Client{}, &model.AuthRequest{}, &model.Token{}, &model.RefreshToken{}, &model.DeviceCode{}, &model.User{}, &model.Session{}, &model.Consent{}, &model.JTI{})
 }
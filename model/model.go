@@ -8,13 +8,31 @@ import (
 	"gorm.io/gorm"
 )
 
+const (
+	AccessTokenFormatOpaque = ""
+	AccessTokenFormatJWT    = "jwt"
+)
+
 type Client struct {
-	ID           uuid.UUID
-	Name         string
-	Secret       string
-	RedirectURIs datatypes.JSONSlice[string]
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	ID                                    uuid.UUID
+	Name                                  string
+	Secret                                string
+	ClientName                            string
+	RedirectURIs                          datatypes.JSONSlice[string]
+	Scopes                                datatypes.JSONSlice[string]
+	GrantTypes                            datatypes.JSONSlice[string]
+	ResponseTypes                         datatypes.JSONSlice[string]
+	TokenEndpointAuthMethod               string
+	AccessTokenFormat                     string
+	RequirePushedAuthorizationRequests    bool
+	JWKS                                  string
+	JWKSURI                               string
+	RequestURIs                           datatypes.JSONSlice[string]
+	TLSClientAuthSubjectDN                string
+	TLSClientCertificateBoundAccessTokens bool
+	RegistrationAccessTokenHash           string
+	CreatedAt                             time.Time
+	UpdatedAt                             time.Time
 }
 
 func (c *Client) BeforeCreate(tx *gorm.DB) (err error) {
@@ -22,47 +40,179 @@ func (c *Client) BeforeCreate(tx *gorm.DB) (err error) {
 	return
 }
 
-type AuthRequest struct {
-	ID           uuid.UUID
-	ClientID     uuid.UUID
-	ResponseType string
-	RedirectURI  string
-	State        string
-	Scope        string
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+type User struct {
+	ID            uuid.UUID
+	Username      string `gorm:"uniqueIndex"`
+	PasswordHash  string
+	Name          string
+	GivenName     string
+	FamilyName    string
+	Email         string
+	EmailVerified bool
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
 }
 
-func (r *AuthRequest) BeforeCreate(tx *gorm.DB) (err error) {
-	r.ID = uuid.New()
+func (u *User) BeforeCreate(tx *gorm.DB) (err error) {
+	u.ID = uuid.New()
 	return
 }
 
-type AuthCode struct {
+type Session struct {
 	ID        uuid.UUID
-	Code      string
-	ClientID  uuid.UUID
+	TokenHash string
+	UserID    uuid.UUID
+	AuthTime  time.Time
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (s *Session) BeforeCreate(tx *gorm.DB) (err error) {
+	s.ID = uuid.New()
+	return
+}
+
+type Consent struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID `gorm:"uniqueIndex:idx_consent_user_client"`
+	ClientID  uuid.UUID `gorm:"uniqueIndex:idx_consent_user_client"`
 	Scope     string
-	Query     string
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+func (c *Consent) BeforeCreate(tx *gorm.DB) (err error) {
+	c.ID = uuid.New()
+	return
+}
+
+type AuthRequest struct {
+	ID                  uuid.UUID
+	ClientID            uuid.UUID
+	ResponseType        string
+	ResponseMode        string
+	RedirectURI         string
+	State               string
+	Scope               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+	Prompt              string
+	Pushed              bool      // pushed to the PAR endpoint and referenced by request_uri
+	UserID              uuid.UUID // the user the consent page was shown to
+	ExpiresAt           time.Time
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
+func (r *AuthRequest) BeforeCreate(tx *gorm.DB) (err error) {
+	r.ID = uuid.New()
+	return
+}
+
+type AuthCode struct {
+	ID                  uuid.UUID
+	Code                string
+	ClientID            uuid.UUID
+	RedirectURI         string
+	Subject             string
+	Scope               string
+	Query               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+	AuthTime            time.Time
+	ExpiresAt           time.Time
+	UsedAt              *time.Time
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
 func (c *AuthCode) BeforeCreate(tx *gorm.DB) (err error) {
 	c.ID = uuid.New()
 	return
 }
 
 type Token struct {
+	ID       uuid.UUID
+	Token    string
+	ClientID uuid.UUID
+	Subject  string
+	Scope    string
+	FamilyID uuid.UUID
+	JKT      string
+	X5TS256  string
+	// set on tokens issued by token exchange
+	Audience  datatypes.JSONSlice[string]
+	Actor     string
+	Revoked   bool
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (t *Token) BeforeCreate(tx *gorm.DB) (err error) {
+	t.ID = uuid.New()
+	return
+}
+
+type RefreshToken struct {
 	ID        uuid.UUID
 	Token     string
 	ClientID  uuid.UUID
+	Subject   string
 	Scope     string
+	FamilyID  uuid.UUID
+	JKT       string
+	Rotated   bool
+	Revoked   bool
+	ExpiresAt time.Time
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
-func (t *Token) BeforeCreate(tx *gorm.DB) (err error) {
+func (t *RefreshToken) BeforeCreate(tx *gorm.DB) (err error) {
 	t.ID = uuid.New()
 	return
 }
+
+const (
+	DeviceCodeStatusPending  = "pending"
+	DeviceCodeStatusApproved = "approved"
+	DeviceCodeStatusDenied   = "denied"
+)
+
+type DeviceCode struct {
+	ID           uuid.UUID
+	DeviceCode   string
+	UserCode     string
+	ClientID     uuid.UUID
+	Subject      string
+	Scope        string
+	Status       string
+	Interval     int
+	LastPolledAt *time.Time
+	ExpiresAt    time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+func (d *DeviceCode) BeforeCreate(tx *gorm.DB) (err error) {
+	d.ID = uuid.New()
+	return
+}
+
+// JTI records a one-time JWT identifier, such as the jti of a DPoP proof,
+// until the JWT carrying it has expired.
+type JTI struct {
+	ID        uuid.UUID
+	Value     string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+func (j *JTI) BeforeCreate(tx *gorm.DB) (err error) {
+	j.ID = uuid.New()
+	return
+}
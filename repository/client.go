@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"github.com/google/uuid"
 	"github.com/voice0726/oauth-playground/model"
 	"go.uber.org/zap"
 	"gorm.io/driver/sqlite"
@@ -45,3 +46,21 @@ func (r *ClientRepository) FindClientByName(name string) (*model.Client, error)
 
 	return result, nil
 }
+
+func (r *ClientRepository) Create(client model.Client) (*model.Client, error) {
+	if err := r.db.Create(&client).Error; err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (r *ClientRepository) Update(client model.Client) (*model.Client, error) {
+	if err := r.db.Save(&client).Error; err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (r *ClientRepository) Delete(ID uuid.UUID) error {
+	return r.db.Where("id = ?", ID).Delete(&model.Client{}).Error
+}
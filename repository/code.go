@@ -1,6 +1,9 @@
 package repository
 
 import (
+	"time"
+
+	"github.com/google/uuid"
 	"github.com/voice0726/oauth-playground/model"
 	"go.uber.org/zap"
 	"gorm.io/driver/sqlite"
@@ -42,7 +45,6 @@ func (r *CodeRepository) FindByCode(code string) (*model.AuthCode, error) {
 	}
 
 	return result, nil
-
 }
 
 func (r *CodeRepository) Create(code model.AuthCode) (*model.AuthCode, error) {
@@ -51,3 +53,19 @@ func (r *CodeRepository) Create(code model.AuthCode) (*model.AuthCode, error) {
 	}
 	return &code, nil
 }
+
+// Consume marks the code as used and reports whether this call was the one
+// that did it, so that a code can only ever be redeemed once.
+func (r *CodeRepository) Consume(ID uuid.UUID) (bool, error) {
+	res := r.db.Model(&model.AuthCode{}).Where("id = ? AND used_at IS NULL", ID).Update("used_at", time.Now())
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// ConsumeByClientAndSubject marks the outstanding codes issued to the client
+// for the subject as used, so that they can no longer be redeemed.
+func (r *CodeRepository) ConsumeByClientAndSubject(clientID uuid.UUID, subject string) error {
+	return r.db.Model(&model.AuthCode{}).Where("client_id = ? AND subject = ? AND used_at IS NULL", clientID, subject).Update("used_at", time.Now()).Error
+}
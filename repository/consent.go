@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	"github.com/voice0726/oauth-playground/model"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"moul.io/zapgorm2"
+)
+
+type ConsentRepository struct {
+	db *gorm.DB
+	lg *zap.Logger
+}
+
+func NewConsentRepository(dsn string, lg *zap.Logger) (*ConsentRepository, error) {
+	zg := zapgorm2.New(lg)
+	zg.SetAsDefault()
+	zg.LogLevel = gormlogger.Error
+	zg.IgnoreRecordNotFoundError = true
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: zg})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConsentRepository{db: db, lg: lg}, nil
+}
+
+func (r *ConsentRepository) Find(userID, clientID uuid.UUID) (*model.Consent, error) {
+	var result model.Consent
+	if err := r.db.Model(&model.Consent{}).Where("user_id = ? AND client_id = ?", userID, clientID).First(&result).Error; err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (r *ConsentRepository) FindByUser(userID uuid.UUID) ([]model.Consent, error) {
+	var result []model.Consent
+	if err := r.db.Model(&model.Consent{}).Where("user_id = ?", userID).Order("updated_at desc").Find(&result).Error; err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (r *ConsentRepository) Save(consent model.Consent) (*model.Consent, error) {
+	if err := r.db.Save(&consent).Error; err != nil {
+		return nil, err
+	}
+	return &consent, nil
+}
+
+func (r *ConsentRepository) Delete(userID, clientID uuid.UUID) (bool, error) {
+	res := r.db.Where("user_id = ? AND client_id = ?", userID, clientID).Delete(&model.Consent{})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
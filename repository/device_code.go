@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/voice0726/oauth-playground/model"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"moul.io/zapgorm2"
+)
+
+type DeviceCodeRepository struct {
+	db *gorm.DB
+	lg *zap.Logger
+}
+
+func NewDeviceCodeRepository(dsn string, lg *zap.Logger) (*DeviceCodeRepository, error) {
+	zg := zapgorm2.New(lg)
+	zg.SetAsDefault()
+	zg.LogLevel = gormlogger.Error
+	zg.IgnoreRecordNotFoundError = true
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: zg})
+	if err != nil {
+		return nil, err
+	}
+	return &DeviceCodeRepository{db: db, lg: lg}, nil
+}
+
+func (r *DeviceCodeRepository) Create(code model.DeviceCode) (*model.DeviceCode, error) {
+	if err := r.db.Create(&code).Error; err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
+
+func (r *DeviceCodeRepository) FindByDeviceCode(deviceCode string) (*model.DeviceCode, error) {
+	var result model.DeviceCode
+	if err := r.db.Model(&model.DeviceCode{}).Where("device_code = ?", deviceCode).First(&result).Error; err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (r *DeviceCodeRepository) FindByUserCode(userCode string) (*model.DeviceCode, error) {
+	var result model.DeviceCode
+	if err := r.db.Model(&model.DeviceCode{}).Where("user_code = ?", userCode).First(&result).Error; err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// UpdateStatus only moves codes out of the pending state, so a decision cannot
+// be overwritten once it has been made.
+func (r *DeviceCodeRepository) UpdateStatus(ID uuid.UUID, status, subject string) (bool, error) {
+	res := r.db.Model(&model.DeviceCode{}).Where("id = ? AND status = ?", ID, model.DeviceCodeStatusPending).Updates(map[string]interface{}{
+		"status":  status,
+		"subject": subject,
+	})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+func (r *DeviceCodeRepository) UpdatePolling(ID uuid.UUID, polledAt time.Time, interval int) error {
+	return r.db.Model(&model.DeviceCode{}).Where("id = ?", ID).Updates(map[string]interface{}{
+		"last_polled_at": polledAt,
+		"interval":       interval,
+	}).Error
+}
+
+// Delete removes the code and reports whether it still existed, which lets
+// the token endpoint redeem an approved code exactly once.
+func (r *DeviceCodeRepository) Delete(ID uuid.UUID) (bool, error) {
+	res := r.db.Where("id = ?", ID).Delete(&model.DeviceCode{})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
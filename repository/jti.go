@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/voice0726/oauth-playground/model"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	gormlogger "gorm.io/gorm/logger"
+	"moul.io/zapgorm2"
+)
+
+type JTIRepository struct {
+	db *gorm.DB
+	lg *zap.Logger
+}
+
+func NewJTIRepository(dsn string, lg *zap.Logger) (*JTIRepository, error) {
+	zg := zapgorm2.New(lg)
+	zg.SetAsDefault()
+	zg.LogLevel = gormlogger.Error
+	zg.IgnoreRecordNotFoundError = true
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: zg})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &JTIRepository{db: db, lg: lg}, nil
+}
+
+// Record stores value until expiresAt and reports whether it was new, so a
+// second use of the same identifier can be rejected as a replay. Expired
+// values are pruned on the way.
+func (r *JTIRepository) Record(value string, expiresAt time.Time) (bool, error) {
+	if err := r.db.Where("expires_at < ?", time.Now()).Delete(&model.JTI{}).Error; err != nil {
+		return false, err
+	}
+
+	res := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.JTI{Value: value, ExpiresAt: expiresAt})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
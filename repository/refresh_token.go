@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	"github.com/voice0726/oauth-playground/model"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"moul.io/zapgorm2"
+)
+
+type RefreshTokenRepository struct {
+	db *gorm.DB
+	lg *zap.Logger
+}
+
+func NewRefreshTokenRepository(dsn string, lg *zap.Logger) (*RefreshTokenRepository, error) {
+	zg := zapgorm2.New(lg)
+	zg.SetAsDefault()
+	zg.LogLevel = gormlogger.Error
+	zg.IgnoreRecordNotFoundError = true
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: zg})
+	if err != nil {
+		return nil, err
+	}
+	return &RefreshTokenRepository{db: db, lg: lg}, nil
+}
+
+func (r *RefreshTokenRepository) Create(token model.RefreshToken) (*model.RefreshToken, error) {
+	if err := r.db.Create(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *RefreshTokenRepository) FindByToken(token string) (*model.RefreshToken, error) {
+	var result model.RefreshToken
+	if err := r.db.Model(&model.RefreshToken{}).Where("token = ?", token).First(&result).Error; err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// MarkRotated flags the token as rotated and reports whether this call was the
+// one that did it, so that two concurrent refreshes cannot both succeed.
+func (r *RefreshTokenRepository) MarkRotated(ID uuid.UUID) (bool, error) {
+	res := r.db.Model(&model.RefreshToken{}).Where("id = ? AND rotated = ?", ID, false).Update("rotated", true)
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+func (r *RefreshTokenRepository) RevokeFamily(familyID uuid.UUID) error {
+	return r.db.Model(&model.RefreshToken{}).Where("family_id = ?", familyID).Update("revoked", true).Error
+}
+
+func (r *RefreshTokenRepository) RevokeByClient(clientID uuid.UUID) error {
+	return r.db.Model(&model.RefreshToken{}).Where("client_id = ?", clientID).Update("revoked", true).Error
+}
+
+// FamilyIDsByClientAndSubject returns the families of the refresh tokens
+// issued to the client for the subject.
+func (r *RefreshTokenRepository) FamilyIDsByClientAndSubject(clientID uuid.UUID, subject string) ([]uuid.UUID, error) {
+	var familyIDs []uuid.UUID
+	if err := r.db.Model(&model.RefreshToken{}).Where("client_id = ? AND subject = ?", clientID, subject).Distinct().Pluck("family_id", &familyIDs).Error; err != nil {
+		return nil, err
+	}
+	return familyIDs, nil
+}
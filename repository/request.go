@@ -1,6 +1,9 @@
 package repository
 
 import (
+	"time"
+
+	"github.com/google/uuid"
 	"github.com/voice0726/oauth-playground/model"
 	"go.uber.org/zap"
 	"gorm.io/driver/sqlite"
@@ -42,3 +45,17 @@ func (r *AuthRequestRepository) FindRequestByID(ID string) (*model.AuthRequest,
 	}
 	return &result, nil
 }
+
+func (r *AuthRequestRepository) UpdateExpiry(ID uuid.UUID, expiresAt time.Time) error {
+	return r.db.Model(&model.AuthRequest{}).Where("id = ?", ID).Update("expires_at", expiresAt).Error
+}
+
+// Delete removes the request and reports whether it still existed, so that a
+// pushed request can be redeemed exactly once.
+func (r *AuthRequestRepository) Delete(ID uuid.UUID) (bool, error) {
+	res := r.db.Where("id = ?", ID).Delete(&model.AuthRequest{})
+	if res.Error != nil {
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"github.com/google/uuid"
+	"github.com/voice0726/oauth-playground/model"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"moul.io/zapgorm2"
+)
+
+type SessionRepository struct {
+	db *gorm.DB
+	lg *zap.Logger
+}
+
+func NewSessionRepository(dsn string, lg *zap.Logger) (*SessionRepository, error) {
+	zg := zapgorm2.New(lg)
+	zg.SetAsDefault()
+	zg.LogLevel = gormlogger.Error
+	zg.IgnoreRecordNotFoundError = true
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: zg})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &SessionRepository{db: db, lg: lg}, nil
+}
+
+func (r *SessionRepository) Create(session model.Session) (*model.Session, error) {
+	if err := r.db.Create(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *SessionRepository) FindByTokenHash(hash string) (*model.Session, error) {
+	var result model.Session
+	if err := r.db.Model(&model.Session{}).Where("token_hash = ?", hash).First(&result).Error; err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (r *SessionRepository) Delete(ID uuid.UUID) error {
+	return r.db.Where("id = ?", ID).Delete(&model.Session{}).Error
+}
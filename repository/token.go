@@ -1,8 +1,7 @@
 package repository
 
 import (
-	"log"
-
+	"github.com/google/uuid"
 	"github.com/voice0726/oauth-playground/model"
 	"go.uber.org/zap"
 	"gorm.io/driver/sqlite"
@@ -29,9 +28,38 @@ func NewTokenRepository(dsn string, lg *zap.Logger) (*TokenRepository, error) {
 }
 
 func (r *TokenRepository) Create(token model.Token) (*model.Token, error) {
-	log.Print(token)
 	if err := r.db.Create(&token).Error; err != nil {
 		return nil, err
 	}
 	return &token, nil
 }
+
+func (r *TokenRepository) FindByToken(token string) (*model.Token, error) {
+	var result model.Token
+	if err := r.db.Model(&model.Token{}).Where("token = ?", token).First(&result).Error; err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (r *TokenRepository) Revoke(ID uuid.UUID) error {
+	return r.db.Model(&model.Token{}).Where("id = ?", ID).Update("revoked", true).Error
+}
+
+func (r *TokenRepository) RevokeFamily(familyID uuid.UUID) error {
+	return r.db.Model(&model.Token{}).Where("family_id = ?", familyID).Update("revoked", true).Error
+}
+
+func (r *TokenRepository) RevokeByClient(clientID uuid.UUID) error {
+	return r.db.Model(&model.Token{}).Where("client_id = ?", clientID).Update("revoked", true).Error
+}
+
+// FamilyIDsByClientAndSubject returns the families of the tokens issued to
+// the client for the subject.
+func (r *TokenRepository) FamilyIDsByClientAndSubject(clientID uuid.UUID, subject string) ([]uuid.UUID, error) {
+	var familyIDs []uuid.UUID
+	if err := r.db.Model(&model.Token{}).Where("client_id = ? AND subject = ?", clientID, subject).Distinct().Pluck("family_id", &familyIDs).Error; err != nil {
+		return nil, err
+	}
+	return familyIDs, nil
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"github.com/voice0726/oauth-playground/model"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+	"moul.io/zapgorm2"
+)
+
+type UserRepository struct {
+	db *gorm.DB
+	lg *zap.Logger
+}
+
+func NewUserRepository(dsn string, lg *zap.Logger) (*UserRepository, error) {
+	zg := zapgorm2.New(lg)
+	zg.SetAsDefault()
+	zg.LogLevel = gormlogger.Error
+	zg.IgnoreRecordNotFoundError = true
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: zg})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &UserRepository{db: db, lg: lg}, nil
+}
+
+func (r *UserRepository) Create(user model.User) (*model.User, error) {
+	if err := r.db.Create(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) FindByID(ID string) (*model.User, error) {
+	var result model.User
+	if err := r.db.Model(&model.User{}).Where("id = ?", ID).First(&result).Error; err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (r *UserRepository) FindByUsername(username string) (*model.User, error) {
+	var result model.User
+	if err := r.db.Model(&model.User{}).Where("username = ?", username).First(&result).Error; err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
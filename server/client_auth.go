@@ -0,0 +1,313 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/voice0726/oauth-playground/model"
+	"go.step.sm/crypto/jose"
+	"gorm.io/gorm"
+)
+
+const (
+	authMethodClientSecretBasic = "client_secret_basic"
+	authMethodClientSecretPost  = "client_secret_post"
+	authMethodClientSecretJWT   = "client_secret_jwt"
+	authMethodPrivateKeyJWT     = "private_key_jwt"
+	authMethodNone              = "none"
+
+	// RFC 7523 section 2.2
+	clientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+)
+
+var tokenEndpointAuthMethodsSupported = []string{
+	authMethodClientSecretBasic,
+	authMethodClientSecretPost,
+	authMethodClientSecretJWT,
+	authMethodPrivateKeyJWT,
+	authMethodTLSClientAuth,
+	authMethodSelfSignedTLSClientAuth,
+	authMethodNone,
+}
+
+// tokenEndpointAuthMethods returns the methods clients can authenticate with,
+// which only include the mutual-TLS ones when there is a listener for them.
+// The slice is a copy that callers may modify.
+func (h *Handler) tokenEndpointAuthMethods() []string {
+	methods := slices.Clone(tokenEndpointAuthMethodsSupported)
+	if h.mtls != nil {
+		return methods
+	}
+	return slices.DeleteFunc(methods, isMTLSAuthMethod)
+}
+
+// algorithms accepted on client_secret_jwt assertions
+var clientSecretSigningAlgsSupported = []string{jose.HS256, jose.HS384, jose.HS512}
+
+var (
+	errClientIDRequired     = errors.New("client id required")
+	errClientSecretRequired = errors.New("client secret required")
+	errInvalidClient        = errors.New("invalid client ID or credential")
+	errInvalidAssertion     = fmt.Errorf("%w: invalid client assertion", errInvalidClient)
+	errReplayedAssertion    = fmt.Errorf("%w: client assertion has already been used", errInvalidClient)
+	errMultipleAuthMethods  = fmt.Errorf("%w: more than one authentication method used", errInvalidClient)
+	errCredentialsInQuery   = errors.New("client credentials must not be sent in the query")
+)
+
+// clientCredentials is what a request presents to authenticate its client,
+// along with the method it implies.
+type clientCredentials struct {
+	method    string
+	clientID  string
+	secret    string
+	assertion string
+}
+
+// authenticateClientRequest authenticates the client of the request with the
+// method it presents, which must be the one the client registered.
+func (h *Handler) authenticateClientRequest(c echo.Context) (*model.Client, error) {
+	cred, err := h.getClientCredentials(c)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := h.findClient(cred.clientID)
+	if err != nil {
+		return nil, err
+	}
+	method := clientAuthMethod(client)
+	if cred.method == authMethodNone {
+		switch {
+		case isMTLSAuthMethod(method):
+			// the credential is the certificate of the connection
+			cred.method = method
+		case method == authMethodClientSecretBasic || method == authMethodClientSecretPost:
+			h.logger.Info("no client secret provided")
+			return nil, errClientSecretRequired
+		}
+	}
+	if cred.method != method {
+		return nil, errInvalidClient
+	}
+
+	switch cred.method {
+	case authMethodClientSecretBasic, authMethodClientSecretPost:
+		if client.Secret == "" || subtle.ConstantTimeCompare([]byte(client.Secret), []byte(cred.secret)) != 1 {
+			return nil, errInvalidClient
+		}
+	case authMethodClientSecretJWT, authMethodPrivateKeyJWT:
+		if err := h.verifyClientAssertion(c, client, cred.assertion); err != nil {
+			return nil, err
+		}
+	case authMethodTLSClientAuth, authMethodSelfSignedTLSClientAuth:
+		if err := h.verifyClientCertificate(c, client); err != nil {
+			return nil, err
+		}
+	case authMethodNone:
+		// public clients only identify themselves
+	default:
+		return nil, errInvalidClient
+	}
+
+	return client, nil
+}
+
+func usesClientSecret(method string) bool {
+	return method == authMethodClientSecretBasic || method == authMethodClientSecretPost || method == authMethodClientSecretJWT
+}
+
+func isClientAuthenticationError(err error) bool {
+	return errors.Is(err, errInvalidClient) || errors.Is(err, errClientIDRequired) || errors.Is(err, errClientSecretRequired)
+}
+
+// clientAuthMethod returns the method the client registered, defaulting to
+// client_secret_basic as RFC 7591 section 2 does.
+func clientAuthMethod(client *model.Client) string {
+	if client.TokenEndpointAuthMethod == "" {
+		return authMethodClientSecretBasic
+	}
+	return client.TokenEndpointAuthMethod
+}
+
+// getClientCredentials reads the client credentials from the Authorization
+// header, a client assertion or the client_id and client_secret form
+// parameters. A request must not use more than one of them (RFC 6749
+// section 2.3).
+func (h *Handler) getClientCredentials(c echo.Context) (*clientCredentials, error) {
+	// the request URI ends up in logs, so credentials are only read from the
+	// body (RFC 6749 section 2.3.1)
+	q := c.Request().URL.Query()
+	if q.Has("client_secret") || q.Has("client_assertion") || q.Has("client_assertion_type") {
+		return nil, errCredentialsInQuery
+	}
+
+	var creds []*clientCredentials
+
+	if auth := c.Request().Header.Get("Authorization"); auth != "" {
+		cred, err := parseBasicAuth(auth)
+		if err != nil {
+			return nil, err
+		}
+		creds = append(creds, cred)
+	}
+	if assertionType := c.Request().PostFormValue("client_assertion_type"); assertionType != "" {
+		cred, err := parseClientAssertion(assertionType, c.Request().PostFormValue("client_assertion"))
+		if err != nil {
+			return nil, err
+		}
+		creds = append(creds, cred)
+	}
+	if secret := c.Request().PostFormValue("client_secret"); secret != "" {
+		creds = append(creds, &clientCredentials{method: authMethodClientSecretPost, secret: secret})
+	}
+	if len(creds) > 1 {
+		return nil, errMultipleAuthMethods
+	}
+
+	clientID := c.FormValue("client_id")
+	if len(creds) == 0 {
+		if clientID == "" {
+			h.logger.Info("no clientid provided")
+			return nil, errClientIDRequired
+		}
+		return &clientCredentials{method: authMethodNone, clientID: clientID}, nil
+	}
+
+	cred := creds[0]
+	if cred.clientID == "" {
+		if clientID == "" {
+			h.logger.Info("no clientid provided")
+			return nil, errClientIDRequired
+		}
+		cred.clientID = clientID
+	} else if clientID != "" && clientID != cred.clientID {
+		return nil, errInvalidClient
+	}
+	return cred, nil
+}
+
+// parseBasicAuth decodes client_secret_basic credentials, which are
+// form-urlencoded before being base64 encoded (RFC 6749 section 2.3.1).
+func parseBasicAuth(auth string) (*clientCredentials, error) {
+	encoded, ok := strings.CutPrefix(auth, "Basic ")
+	if !ok {
+		return nil, errInvalidClient
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errInvalidClient
+	}
+	rawID, rawSecret, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return nil, errInvalidClient
+	}
+	clientID, err := url.QueryUnescape(rawID)
+	if err != nil {
+		return nil, errInvalidClient
+	}
+	secret, err := url.QueryUnescape(rawSecret)
+	if err != nil {
+		return nil, errInvalidClient
+	}
+	if clientID == "" {
+		return nil, errClientIDRequired
+	}
+	if secret == "" {
+		return nil, errClientSecretRequired
+	}
+	return &clientCredentials{method: authMethodClientSecretBasic, clientID: clientID, secret: secret}, nil
+}
+
+// parseClientAssertion reads the client ID from the unverified sub claim of a
+// JWT client assertion and tells client_secret_jwt from private_key_jwt by
+// its algorithm. The signature is checked by verifyClientAssertion.
+func parseClientAssertion(assertionType, assertion string) (*clientCredentials, error) {
+	if assertionType != clientAssertionTypeJWTBearer || assertion == "" {
+		return nil, errInvalidAssertion
+	}
+	tok, err := jose.ParseSigned(assertion)
+	if err != nil || len(tok.Headers) != 1 {
+		return nil, errInvalidAssertion
+	}
+	var claims jose.Claims
+	if err := tok.UnsafeClaimsWithoutVerification(&claims); err != nil || claims.Subject == "" {
+		return nil, errInvalidAssertion
+	}
+
+	method := authMethodPrivateKeyJWT
+	if slices.Contains(clientSecretSigningAlgsSupported, tok.Headers[0].Algorithm) {
+		method = authMethodClientSecretJWT
+	}
+	return &clientCredentials{method: method, clientID: claims.Subject, assertion: assertion}, nil
+}
+
+// verifyClientAssertion checks a client_secret_jwt or private_key_jwt
+// assertion (RFC 7523 section 3) and records its jti so that it cannot be
+// replayed.
+func (h *Handler) verifyClientAssertion(c echo.Context, client *model.Client, assertion string) error {
+	var claims jose.Claims
+	switch client.TokenEndpointAuthMethod {
+	case authMethodClientSecretJWT:
+		tok, err := jose.ParseSigned(assertion)
+		if err != nil || !slices.Contains(clientSecretSigningAlgsSupported, tok.Headers[0].Algorithm) {
+			return errInvalidAssertion
+		}
+		if client.Secret == "" || jose.Verify(tok, []byte(client.Secret), &claims) != nil {
+			return errInvalidAssertion
+		}
+	case authMethodPrivateKeyJWT:
+		if err := h.verifyClientJWT(client, assertion, &claims); err != nil {
+			if errors.Is(err, errClientKeysNotRegistered) {
+				return errInvalidAssertion
+			}
+			return fmt.Errorf("%w: %w", errInvalidAssertion, err)
+		}
+	default:
+		return errInvalidClient
+	}
+
+	expected := jose.Expected{
+		Issuer:  client.Name,
+		Subject: client.Name,
+		Time:    time.Now(),
+	}
+	if err := claims.ValidateWithLeeway(expected, time.Minute); err != nil {
+		return fmt.Errorf("%w: %w", errInvalidAssertion, err)
+	}
+	if claims.Expiry == nil || claims.ID == "" {
+		return fmt.Errorf("%w: exp and jti are required", errInvalidAssertion)
+	}
+	// the token endpoint is the expected audience, but the issuer and the
+	// endpoint the assertion was sent to are accepted as well
+	audiences := []string{h.issuer, h.issuer + "/token", h.baseURL(c) + c.Request().URL.Path}
+	if !slices.ContainsFunc(audiences, claims.Audience.Contains) {
+		return fmt.Errorf("%w: unexpected audience", errInvalidAssertion)
+	}
+
+	fresh, err := h.jtiRepository.Record("client_assertion:"+client.Name+":"+claims.ID, claims.Expiry.Time().Add(time.Minute))
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return errReplayedAssertion
+	}
+	return nil
+}
+
+func (h *Handler) findClient(clientID string) (*model.Client, error) {
+	client, err := h.clientRepository.FindClientByName(clientID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errInvalidClient
+		}
+		return nil, err
+	}
+	return client, nil
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/voice0726/oauth-playground/model"
+	"go.step.sm/crypto/jose"
+)
+
+func basic(s string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func TestParseBasicAuth(t *testing.T) {
+	tests := []struct {
+		name       string
+		auth       string
+		wantID     string
+		wantSecret string
+		wantErr    error
+	}{
+		{name: "valid", auth: basic("client:secret"), wantID: "client", wantSecret: "secret"},
+		{name: "form-urlencoded", auth: basic("my%3Aclient:p%40ss+word"), wantID: "my:client", wantSecret: "p@ss word"},
+		{name: "colon in secret", auth: basic("client:sec:ret"), wantID: "client", wantSecret: "sec:ret"},
+		{name: "bearer scheme", auth: "Bearer token", wantErr: errInvalidClient},
+		{name: "invalid base64", auth: "Basic !!!", wantErr: errInvalidClient},
+		{name: "no colon", auth: basic("client"), wantErr: errInvalidClient},
+		{name: "invalid escape", auth: basic("client:%zz"), wantErr: errInvalidClient},
+		{name: "empty client id", auth: basic(":secret"), wantErr: errClientIDRequired},
+		{name: "empty secret", auth: basic("client:"), wantErr: errClientSecretRequired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cred, err := parseBasicAuth(tt.auth)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("parseBasicAuth() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseBasicAuth() error = %v", err)
+			}
+			if cred.method != authMethodClientSecretBasic || cred.clientID != tt.wantID || cred.secret != tt.wantSecret {
+				t.Errorf("parseBasicAuth() = %+v, want %s:%s", cred, tt.wantID, tt.wantSecret)
+			}
+		})
+	}
+}
+
+func signAssertion(t *testing.T, alg string, key interface{}, claims jose.Claims) string {
+	t.Helper()
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.SignatureAlgorithm(alg), Key: key}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertion, err := jose.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return assertion
+}
+
+func TestParseClientAssertion(t *testing.T) {
+	key, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	claims := jose.Claims{Issuer: "client", Subject: "client"}
+
+	tests := []struct {
+		name          string
+		assertionType string
+		assertion     string
+		wantMethod    string
+		wantErr       bool
+	}{
+		{name: "private_key_jwt", assertion: signAssertion(t, jose.ES256, key, claims), wantMethod: authMethodPrivateKeyJWT},
+		{name: "client_secret_jwt", assertion: signAssertion(t, jose.HS256, secret, claims), wantMethod: authMethodClientSecretJWT},
+		{name: "unknown assertion type", assertionType: "urn:example:saml", assertion: signAssertion(t, jose.ES256, key, claims), wantErr: true},
+		{name: "empty assertion", wantErr: true},
+		{name: "not a JWT", assertion: "not-a-jwt", wantErr: true},
+		{name: "no sub", assertion: signAssertion(t, jose.ES256, key, jose.Claims{Issuer: "client"}), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertionType := tt.assertionType
+			if assertionType == "" {
+				assertionType = clientAssertionTypeJWTBearer
+			}
+			cred, err := parseClientAssertion(assertionType, tt.assertion)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidAssertion) {
+					t.Fatalf("parseClientAssertion() error = %v, want errInvalidAssertion", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseClientAssertion() error = %v", err)
+			}
+			if cred.method != tt.wantMethod || cred.clientID != "client" || cred.assertion != tt.assertion {
+				t.Errorf("parseClientAssertion() = %+v, want method %s for client", cred, tt.wantMethod)
+			}
+		})
+	}
+}
+
+func TestClientCredentialsInQuery(t *testing.T) {
+	s := newTestServer(t)
+	client := s.createClient(t, model.Client{TokenEndpointAuthMethod: authMethodClientSecretPost, Secret: "secret", GrantTypes: []string{"client_credentials"}})
+	form := url.Values{"grant_type": {"client_credentials"}, "client_id": {client.Name}}
+
+	for _, query := range []string{
+		"client_secret=secret",
+		"client_assertion_type=" + url.QueryEscape(clientAssertionTypeJWTBearer) + "&client_assertion=x.y.z",
+	} {
+		for _, path := range []string{"/token", "/introspect", "/revoke", "/par", "/device_authorization"} {
+			rec := s.postForm(path+"?"+query, form, "", "")
+			if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_request") {
+				t.Errorf("POST %s?%s: status = %d, body = %s", path, query, rec.Code, rec.Body)
+			}
+		}
+	}
+
+	form.Set("client_secret", "secret")
+	if rec := s.postForm("/token", form, "", ""); rec.Code != http.StatusOK {
+		t.Errorf("client_secret in the body: status = %d, body = %s", rec.Code, rec.Body)
+	}
+}
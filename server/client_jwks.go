@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/voice0726/oauth-playground/model"
+	"go.step.sm/crypto/jose"
+)
+
+// maxFetchSize caps documents fetched from client-controlled URLs.
+const maxFetchSize = 64 << 10
+
+// asymmetric algorithms accepted on JWTs signed by clients
+var clientSigningAlgsSupported = []string{jose.ES256, jose.ES384, jose.ES512, jose.RS256, jose.PS256, jose.EdDSA}
+
+var errClientKeysNotRegistered = errors.New("client has no registered keys")
+
+// clientKeySet returns the keys registered by value in jwks, or fetched from
+// the client's jwks_uri.
+func (h *Handler) clientKeySet(client *model.Client) (*jose.JSONWebKeySet, error) {
+	return h.keySet(client.JWKS, client.JWKSURI)
+}
+
+func (h *Handler) keySet(jwks, jwksURI string) (*jose.JSONWebKeySet, error) {
+	var keys jose.JSONWebKeySet
+	switch {
+	case jwks != "":
+		if err := json.Unmarshal([]byte(jwks), &keys); err != nil {
+			return nil, err
+		}
+	case jwksURI != "":
+		b, err := h.fetch(jwksURI, "application/json")
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &keys); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errClientKeysNotRegistered
+	}
+	return &keys, nil
+}
+
+// verifyClientJWT checks the signature of raw against the client's keys and
+// decodes its claims into dest. Claim validation is left to the caller.
+func (h *Handler) verifyClientJWT(client *model.Client, raw string, dest ...interface{}) error {
+	keys, err := h.clientKeySet(client)
+	if err != nil {
+		return err
+	}
+	return verifyJWT(keys, raw, dest...)
+}
+
+// verifyJWT checks the signature of raw against keys and decodes its claims
+// into dest.
+func verifyJWT(keys *jose.JSONWebKeySet, raw string, dest ...interface{}) error {
+	tok, err := jose.ParseSigned(raw)
+	if err != nil {
+		return err
+	}
+	if len(tok.Headers) != 1 {
+		return errors.New("unexpected number of signatures")
+	}
+	header := tok.Headers[0]
+	if !slices.Contains(clientSigningAlgsSupported, header.Algorithm) {
+		return fmt.Errorf("unsupported signing algorithm %q", header.Algorithm)
+	}
+
+	candidates := keys.Keys
+	if header.KeyID != "" {
+		candidates = keys.Key(header.KeyID)
+	}
+	for _, key := range candidates {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		if err := jose.Verify(tok, key.Key, dest...); err == nil {
+			return nil
+		}
+	}
+	return errors.New("no registered key verifies the signature")
+}
+
+func (h *Handler) fetch(uri, accept string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", accept)
+
+	res, err := h.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %d", uri, res.StatusCode)
+	}
+	b, err := io.ReadAll(io.LimitReader(res.Body, maxFetchSize))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.TrimSpace(string(b))), nil
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/voice0726/oauth-playground/model"
+	"go.step.sm/crypto/jose"
+)
+
+// ClientPolicy is what the operator allows a client to do beyond its
+// registered metadata. These permissions let a client obtain tokens for users
+// without their involvement, so they are configured with the server and can
+// never be set through dynamic registration.
+type ClientPolicy struct {
+	// issuers whose JWTs the client may present with the jwt-bearer grant
+	JWTBearerIssuers []TrustedIssuer `json:"jwt_bearer_issuers,omitempty"`
+	// what the client may do with the token-exchange grant
+	TokenExchange TokenExchangePolicy `json:"token_exchange,omitempty"`
+}
+
+type TokenExchangePolicy struct {
+	// client IDs whose access tokens the client may exchange, besides its own
+	SubjectClients []string `json:"subject_clients,omitempty"`
+	// audiences and resources the client may request tokens for
+	Audiences []string `json:"audiences,omitempty"`
+	// whether the client may exchange without an actor token
+	AllowImpersonation bool `json:"allow_impersonation,omitempty"`
+}
+
+// TrustedIssuer is an issuer whose JWTs a client may present with the
+// jwt-bearer grant, along with the keys that verify them.
+type TrustedIssuer struct {
+	Issuer  string              `json:"issuer"`
+	JWKSURI string              `json:"jwks_uri,omitempty"`
+	JWKS    *jose.JSONWebKeySet `json:"jwks,omitempty"`
+}
+
+// LoadClientPolicies reads client policies keyed by client ID from a JSON
+// file.
+func LoadClientPolicies(path string) (map[string]ClientPolicy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policies map[string]ClientPolicy
+	if err := json.Unmarshal(b, &policies); err != nil {
+		return nil, err
+	}
+
+	for clientID, policy := range policies {
+		for _, ti := range policy.JWTBearerIssuers {
+			if ti.Issuer == "" {
+				return nil, fmt.Errorf("%s: jwt_bearer_issuers entries require an issuer", clientID)
+			}
+			if (ti.JWKS == nil) == (ti.JWKSURI == "") {
+				return nil, fmt.Errorf("%s: jwt_bearer_issuers entries require exactly one of jwks and jwks_uri", clientID)
+			}
+			if ti.JWKS == nil {
+				continue
+			}
+			for _, key := range ti.JWKS.Keys {
+				if !key.Valid() || !key.IsPublic() {
+					return nil, fmt.Errorf("%s: jwks of %s must only contain valid public keys", clientID, ti.Issuer)
+				}
+			}
+		}
+	}
+	return policies, nil
+}
+
+// clientPolicy returns the policy the operator configured for client, which
+// is empty for most clients.
+func (h *Handler) clientPolicy(client *model.Client) ClientPolicy {
+	return h.policies[client.Name]
+}
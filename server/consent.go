@@ -0,0 +1,122 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/voice0726/oauth-playground/model"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type grant struct {
+	Client *model.Client
+	Scopes []scopeDefinition
+	model.Consent
+}
+
+// saveConsent remembers the scopes the user approved for the client, adding
+// them to whatever was granted before.
+func (h *Handler) saveConsent(user *model.User, req *model.AuthRequest) error {
+	consent, err := h.consentRepository.Find(user.ID, req.ClientID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		consent = &model.Consent{UserID: user.ID, ClientID: req.ClientID}
+	}
+	consent.Scope = mergeScopes(consent.Scope, req.Scope)
+	_, err = h.consentRepository.Save(*consent)
+	return err
+}
+
+func (h *Handler) HandleGrants(c echo.Context) error {
+	_, user, err := h.currentSession(c)
+	if err != nil {
+		if errors.Is(err, errNoSession) {
+			return redirectToLogin(c)
+		}
+		h.logger.Error("failed to get session", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+
+	consents, err := h.consentRepository.FindByUser(user.ID)
+	if err != nil {
+		h.logger.Error("failed to get consents", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+
+	grants := make([]grant, 0, len(consents))
+	for _, consent := range consents {
+		client, err := h.clientRepository.FindClientByID(consent.ClientID.String())
+		if err != nil {
+			// the client may have been deleted since
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			h.logger.Error("failed to get client", zap.Error(err))
+			return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+		}
+		grants = append(grants, grant{Client: client, Scopes: describeScopes(consent.Scope), Consent: consent})
+	}
+
+	return c.Render(http.StatusOK, "grants.html", map[string]interface{}{"user": user, "grants": grants})
+}
+
+// revokeGrant invalidates everything the user granted the client: codes that
+// are yet to be redeemed, and every token family issued from the grant. The
+// families include tokens other clients obtained from it by token exchange.
+func (h *Handler) revokeGrant(clientID uuid.UUID, subject string) error {
+	if err := h.codeRepostiroy.ConsumeByClientAndSubject(clientID, subject); err != nil {
+		return err
+	}
+
+	familyIDs, err := h.tokenRepository.FamilyIDsByClientAndSubject(clientID, subject)
+	if err != nil {
+		return err
+	}
+	refreshFamilyIDs, err := h.refreshTokenRepository.FamilyIDsByClientAndSubject(clientID, subject)
+	if err != nil {
+		return err
+	}
+	for _, familyID := range append(familyIDs, refreshFamilyIDs...) {
+		if err := h.revokeFamily(familyID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Handler) HandleRevokeGrant(c echo.Context) error {
+	_, user, err := h.currentSession(c)
+	if err != nil {
+		if errors.Is(err, errNoSession) {
+			return c.Render(http.StatusUnauthorized, "error.html", map[string]string{"error": "login required"})
+		}
+		h.logger.Error("failed to get session", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+
+	clientID, err := uuid.Parse(c.FormValue("client_id"))
+	if err != nil {
+		return c.Render(http.StatusBadRequest, "error.html", map[string]string{"error": "invalid client"})
+	}
+
+	deleted, err := h.consentRepository.Delete(user.ID, clientID)
+	if err != nil {
+		h.logger.Error("failed to delete consent", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+	if !deleted {
+		return c.Render(http.StatusBadRequest, "error.html", map[string]string{"error": "invalid client"})
+	}
+
+	if err := h.revokeGrant(clientID, user.ID.String()); err != nil {
+		h.logger.Error("failed to revoke grant", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+
+	return c.Redirect(http.StatusSeeOther, "/grants")
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/voice0726/oauth-playground/model"
+)
+
+func TestConsent(t *testing.T) {
+	s := newTestServer(t)
+	client := s.createClient(t, model.Client{})
+	user, session := s.login(t)
+	_, otherSession := s.login(t)
+
+	authorizeQuery := url.Values{
+		"client_id":     {client.Name},
+		"redirect_uri":  {client.RedirectURIs[0]},
+		"response_type": {"code"},
+		"scope":         {"profile"},
+	}
+	authorize := func(session *http.Cookie) (int, string) {
+		rec := s.get("/authorize?"+authorizeQuery.Encode(), session)
+		if rec.Code == http.StatusOK {
+			return rec.Code, ""
+		}
+		return rec.Code, redirectParams(t, rec).Get("code")
+	}
+
+	reqID := s.openConsentPage(t, client, session)
+	redirectParams(t, s.postForm("/approve", url.Values{"reqid": {reqID}, "approve": {"Approve"}}, "", "", session))
+
+	// the consent is remembered for the user, and only for them
+	status, redeemed := authorize(session)
+	if status != http.StatusSeeOther || redeemed == "" {
+		t.Fatalf("remembered consent: status = %d", status)
+	}
+	if status, _ := authorize(otherSession); status != http.StatusOK {
+		t.Errorf("another user: status = %d, want the consent page", status)
+	}
+	_, outstanding := authorize(session)
+
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {redeemed}, "redirect_uri": {client.RedirectURIs[0]}}
+	res := decodeTokenResponse(t, s.tokenRequest(t, client, form, nil))
+
+	if rec := s.postForm("/grants/revoke", url.Values{"client_id": {client.ID.String()}}, "", "", otherSession); rec.Code != http.StatusBadRequest {
+		t.Errorf("revoke by another user: status = %d", rec.Code)
+	}
+	if rec := s.postForm("/grants/revoke", url.Values{"client_id": {client.ID.String()}}, "", "", session); rec.Code != http.StatusSeeOther {
+		t.Fatalf("revoke: status = %d: %s", rec.Code, rec.Body)
+	}
+
+	at, err := s.h.tokenRepository.FindByToken(res.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isAccessTokenActive(at) || at.Subject != user.ID.String() {
+		t.Error("access token is still active after the grant was revoked")
+	}
+	refresh := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {res.RefreshToken}}
+	if rec := s.tokenRequest(t, client, refresh, nil); tokenError(t, rec) != "invalid_grant" {
+		t.Errorf("refresh after revoke: %s", rec.Body)
+	}
+	form.Set("code", outstanding)
+	if rec := s.tokenRequest(t, client, form, nil); tokenError(t, rec) != "invalid_grant" {
+		t.Errorf("outstanding code after revoke: %s", rec.Body)
+	}
+	if status, _ := authorize(session); status != http.StatusOK {
+		t.Errorf("after revoke: status = %d, want the consent page", status)
+	}
+}
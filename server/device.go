@@ -0,0 +1,253 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/voice0726/oauth-playground/model"
+	"go.step.sm/crypto/randutil"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	grantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+	deviceCodeTTL          = 10 * time.Minute
+	devicePollInterval     = 5
+	deviceSlowDownInterval = 5
+
+	// RFC 8628 section 6.1: consonants only, to avoid forming words and to
+	// keep the code easy to type on a second device.
+	userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+)
+
+func (h *Handler) HandleDeviceAuthorization(c echo.Context) error {
+	client, err := h.authenticateClientRequest(c)
+	if err != nil {
+		if errors.Is(err, errCredentialsInQuery) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": err.Error()})
+		}
+		if errors.Is(err, errClientIDRequired) || errors.Is(err, errClientSecretRequired) {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		if errors.Is(err, errInvalidClient) {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		}
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+
+	if !isGrantTypeAllowed(client, grantTypeDeviceCode) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "unauthorized_client"})
+	}
+
+	scope := c.FormValue("scope")
+	if err := validateScope(client, scope); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_scope"})
+	}
+
+	deviceCode, err := randutil.Alphanumeric(40)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+	userCode, err := randutil.String(8, userCodeCharset)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+	userCode = userCode[:4] + "-" + userCode[4:]
+
+	dc, err := h.deviceCodeRepository.Create(model.DeviceCode{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		ClientID:   client.ID,
+		Scope:      scope,
+		Status:     model.DeviceCodeStatusPending,
+		Interval:   devicePollInterval,
+		ExpiresAt:  time.Now().Add(deviceCodeTTL),
+	})
+	if err != nil {
+		h.logger.Error("failed to save device code", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+
+	verificationURI := c.Scheme() + "://" + c.Request().Host + "/device"
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"device_code":               dc.DeviceCode,
+		"user_code":                 dc.UserCode,
+		"verification_uri":          verificationURI,
+		"verification_uri_complete": verificationURI + "?user_code=" + url.QueryEscape(dc.UserCode),
+		"expires_in":                int64(deviceCodeTTL.Seconds()),
+		"interval":                  dc.Interval,
+	})
+}
+
+func (h *Handler) HandleDevice(c echo.Context) error {
+	if _, _, err := h.currentSession(c); err != nil {
+		if errors.Is(err, errNoSession) {
+			return redirectToLogin(c)
+		}
+		h.logger.Error("failed to get session", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+
+	return c.Render(http.StatusOK, "device.html", map[string]string{"user_code": c.QueryParam("user_code")})
+}
+
+func (h *Handler) HandleDeviceVerify(c echo.Context) error {
+	_, user, err := h.currentSession(c)
+	if err != nil {
+		if errors.Is(err, errNoSession) {
+			return c.Render(http.StatusUnauthorized, "error.html", map[string]string{"error": "login required"})
+		}
+		h.logger.Error("failed to get session", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+
+	userCode := normalizeUserCode(c.FormValue("user_code"))
+
+	dc, err := h.deviceCodeRepository.FindByUserCode(userCode)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Render(http.StatusBadRequest, "device.html", map[string]string{"error": "unknown code"})
+		}
+		h.logger.Error("failed to get device code", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+
+	if dc.Status != model.DeviceCodeStatusPending || time.Now().After(dc.ExpiresAt) {
+		return c.Render(http.StatusBadRequest, "device.html", map[string]string{"error": "the code has expired or was already used"})
+	}
+
+	client, err := h.clientRepository.FindClientByID(dc.ClientID.String())
+	if err != nil {
+		h.logger.Error("failed to get client", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "failed to get client"})
+	}
+
+	return c.Render(http.StatusOK, "device_approve.html", map[string]interface{}{"user_code": dc.UserCode, "scopes": describeScopes(dc.Scope), "client": client, "user": user})
+}
+
+func (h *Handler) HandleDeviceApprove(c echo.Context) error {
+	var b struct {
+		UserCode string `form:"user_code"`
+		Approve  string `form:"approve"`
+	}
+	err := (&echo.DefaultBinder{}).BindBody(c, &b)
+	if err != nil {
+		h.logger.Error("failed to parse request body", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+
+	_, user, err := h.currentSession(c)
+	if err != nil {
+		if errors.Is(err, errNoSession) {
+			return c.Render(http.StatusUnauthorized, "error.html", map[string]string{"error": "login required"})
+		}
+		h.logger.Error("failed to get session", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+
+	dc, err := h.deviceCodeRepository.FindByUserCode(normalizeUserCode(b.UserCode))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Render(http.StatusBadRequest, "device.html", map[string]string{"error": "unknown code"})
+		}
+		h.logger.Error("failed to get device code", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+
+	if time.Now().After(dc.ExpiresAt) {
+		return c.Render(http.StatusBadRequest, "device.html", map[string]string{"error": "the code has expired or was already used"})
+	}
+
+	status := model.DeviceCodeStatusDenied
+	message := "The device was denied access."
+	if b.Approve == "Approve" {
+		status = model.DeviceCodeStatusApproved
+		message = "The device was approved. You can return to it now."
+	}
+
+	updated, err := h.deviceCodeRepository.UpdateStatus(dc.ID, status, user.ID.String())
+	if err != nil {
+		h.logger.Error("failed to update device code", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+	if !updated {
+		return c.Render(http.StatusBadRequest, "device.html", map[string]string{"error": "the code has expired or was already used"})
+	}
+
+	return c.Render(http.StatusOK, "device.html", map[string]string{"message": message})
+}
+
+func (h *Handler) handleDeviceCodeGrant(c echo.Context, client *model.Client, body *tokenRequest) error {
+	if body.DeviceCode == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "device code required"})
+	}
+
+	dc, err := h.deviceCodeRepository.FindByDeviceCode(body.DeviceCode)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			h.logger.Info("device code not found")
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		}
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+
+	if dc.ClientID != client.ID {
+		h.logger.Info("device code was issued to another client", zap.String("expected", dc.ClientID.String()), zap.String("got", client.ID.String()))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+	}
+
+	now := time.Now()
+	if now.After(dc.ExpiresAt) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "expired_token"})
+	}
+
+	interval := dc.Interval
+	tooFast := dc.LastPolledAt != nil && now.Sub(*dc.LastPolledAt) < time.Duration(dc.Interval)*time.Second
+	if tooFast {
+		interval += deviceSlowDownInterval
+	}
+	if err := h.deviceCodeRepository.UpdatePolling(dc.ID, now, interval); err != nil {
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+	if tooFast {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{"error": "slow_down", "interval": interval})
+	}
+
+	switch dc.Status {
+	case model.DeviceCodeStatusPending:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "authorization_pending"})
+	case model.DeviceCodeStatusDenied:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "access_denied"})
+	}
+
+	deleted, err := h.deviceCodeRepository.Delete(dc.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+	if !deleted {
+		h.logger.Info("device code already redeemed")
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+	}
+
+	res, err := h.issueTokens(client, dc.Subject, dc.Scope, dc.Scope, uuid.New(), body.cnf)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+func normalizeUserCode(code string) string {
+	code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != 8 {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
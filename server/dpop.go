@@ -0,0 +1,141 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/voice0726/oauth-playground/dpop"
+	"github.com/voice0726/oauth-playground/model"
+	"gorm.io/gorm"
+)
+
+const tokenTypeDPoP = "DPoP"
+
+var (
+	errMissingAccessToken = errors.New("missing access token")
+	errInvalidAccessToken = errors.New("invalid access token")
+	errReplayedDPoPProof  = fmt.Errorf("%w: jti has already been used", dpop.ErrInvalidProof)
+)
+
+// confirmation is the cnf claim of a sender-constrained token (RFC 7800).
+type confirmation struct {
+	JKT string `json:"jkt,omitempty"`
+	// thumbprint of the client certificate (RFC 8705 section 3.1)
+	X5TS256 string `json:"x5t#S256,omitempty"`
+}
+
+func tokenConfirmation(t *model.Token) *confirmation {
+	if t.JKT == "" && t.X5TS256 == "" {
+		return nil
+	}
+	return &confirmation{JKT: t.JKT, X5TS256: t.X5TS256}
+}
+
+func tokenType(t *model.Token) string {
+	if t.JKT != "" {
+		return tokenTypeDPoP
+	}
+	return "Bearer"
+}
+
+// verifyTokenRequestDPoP checks the DPoP proof sent to the token endpoint, if
+// there is one, and returns the confirmation to bind the issued tokens to.
+func (h *Handler) verifyTokenRequestDPoP(c echo.Context) (*confirmation, error) {
+	proofs := c.Request().Header.Values(dpop.HeaderName)
+	if len(proofs) == 0 {
+		return nil, nil
+	}
+	if len(proofs) > 1 {
+		return nil, fmt.Errorf("%w: exactly one DPoP header is allowed", dpop.ErrInvalidProof)
+	}
+
+	proof, err := dpop.Verify(proofs[0], c.Request().Method, h.baseURL(c)+c.Request().URL.Path, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := h.recordDPoPProof(proof); err != nil {
+		return nil, err
+	}
+	return &confirmation{JKT: proof.JKT}, nil
+}
+
+func (h *Handler) recordDPoPProof(proof *dpop.Proof) error {
+	fresh, err := h.jtiRepository.Record("dpop:"+proof.JKT+":"+proof.JTI, proof.IssuedAt.Add(dpop.MaxAge))
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return errReplayedDPoPProof
+	}
+	return nil
+}
+
+// authenticateAccessToken is the resource side of token binding: it returns
+// the active access token of the request, which must come with a valid DPoP
+// proof if the token is bound to a key and over a connection authenticated
+// with the same certificate if it is bound to one.
+func (h *Handler) authenticateAccessToken(c echo.Context) (*model.Token, error) {
+	token, bound := dpop.AccessToken(c.Request())
+	if token == "" {
+		return nil, errMissingAccessToken
+	}
+
+	t, err := h.tokenRepository.FindByToken(token)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errInvalidAccessToken
+		}
+		return nil, err
+	}
+	if !isAccessTokenActive(t) {
+		return nil, errInvalidAccessToken
+	}
+	if t.X5TS256 != "" {
+		cert := clientCertificate(c)
+		if cert == nil || certificateThumbprint(cert) != t.X5TS256 {
+			return nil, errInvalidAccessToken
+		}
+	}
+
+	if t.JKT == "" {
+		if bound {
+			return nil, errInvalidAccessToken
+		}
+		return t, nil
+	}
+	// a bound token presented as a bearer token is not enough (RFC 9449
+	// section 7.2)
+	if !bound {
+		return nil, errInvalidAccessToken
+	}
+	proof, err := dpop.VerifyRequest(c.Request(), h.baseURL(c), token, t.JKT)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.recordDPoPProof(proof); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// accessTokenErrorResponse answers a request that failed
+// authenticateAccessToken, challenging for both schemes unless the proof
+// itself was at fault (RFC 9449 section 7.1).
+func accessTokenErrorResponse(c echo.Context, err error) error {
+	algs := `algs="` + strings.Join(dpop.SigningAlgs, " ") + `"`
+	switch {
+	case errors.Is(err, errMissingAccessToken):
+		c.Response().Header().Set("WWW-Authenticate", "Bearer, DPoP "+algs)
+		return c.NoContent(http.StatusUnauthorized)
+	case errors.Is(err, errInvalidAccessToken):
+		c.Response().Header().Set("WWW-Authenticate", `Bearer error="invalid_token", DPoP error="invalid_token", `+algs)
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+	case errors.Is(err, dpop.ErrInvalidProof):
+		c.Response().Header().Set("WWW-Authenticate", `DPoP error="invalid_dpop_proof", `+algs)
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid_dpop_proof", "error_description": err.Error()})
+	}
+	return c.JSON(http.StatusInternalServerError, "internal server error")
+}
@@ -1,15 +1,17 @@
 package server
 
 import (
-	"encoding/base64"
 	"errors"
-	"log"
 	"net/http"
 	"net/url"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+	"github.com/voice0726/oauth-playground/dpop"
 	"github.com/voice0726/oauth-playground/model"
 	"github.com/voice0726/oauth-playground/repository"
 	"go.step.sm/crypto/randutil"
@@ -19,12 +21,32 @@ import (
 
 var ErrClientNotFound error
 
+const (
+	// how long the user has to approve a request on the consent page
+	authRequestTTL = 10 * time.Minute
+	authCodeTTL    = time.Minute
+	// 43 alphanumeric characters carry about 256 bits of entropy
+	authCodeLength = 43
+)
+
 type Handler struct {
-	clientRepository      *repository.ClientRepository
-	authRequestRepository *repository.AuthRequestRepository
-	codeRepostiroy        *repository.CodeRepository
-	tokenRepository       *repository.TokenRepository
-	logger                *zap.Logger
+	httpClient             *http.Client
+	clientRepository       *repository.ClientRepository
+	authRequestRepository  *repository.AuthRequestRepository
+	codeRepostiroy         *repository.CodeRepository
+	tokenRepository        *repository.TokenRepository
+	refreshTokenRepository *repository.RefreshTokenRepository
+	deviceCodeRepository   *repository.DeviceCodeRepository
+	jtiRepository          *repository.JTIRepository
+	userRepository         *repository.UserRepository
+	sessionRepository      *repository.SessionRepository
+	consentRepository      *repository.ConsentRepository
+	issuer                 string
+	mtls                   *MTLSConfig
+	policies               map[string]ClientPolicy
+	keys                   *keySet
+	grants                 map[string]grantHandler
+	logger                 *zap.Logger
 }
 
 func NewHandler(
@@ -32,24 +54,157 @@ func NewHandler(
 	authRequestRepository *repository.AuthRequestRepository,
 	codeRepository *repository.CodeRepository,
 	tokenRepository *repository.TokenRepository,
+	refreshTokenRepository *repository.RefreshTokenRepository,
+	deviceCodeRepository *repository.DeviceCodeRepository,
+	jtiRepository *repository.JTIRepository,
+	userRepository *repository.UserRepository,
+	sessionRepository *repository.SessionRepository,
+	consentRepository *repository.ConsentRepository,
+	issuer string,
+	mtls *MTLSConfig,
+	policies map[string]ClientPolicy,
 	logger *zap.Logger,
 ) (*Handler, error) {
-	return &Handler{clientRepository: clientRepo, authRequestRepository: authRequestRepository, codeRepostiroy: codeRepository, tokenRepository: tokenRepository, logger: logger}, nil
+	keys, err := newKeySet()
+	if err != nil {
+		return nil, err
+	}
+	h := &Handler{httpClient: &http.Client{Timeout: 5 * time.Second}, clientRepository: clientRepo, authRequestRepository: authRequestRepository, codeRepostiroy: codeRepository, tokenRepository: tokenRepository, refreshTokenRepository: refreshTokenRepository, deviceCodeRepository: deviceCodeRepository, jtiRepository: jtiRepository, userRepository: userRepository, sessionRepository: sessionRepository, consentRepository: consentRepository, issuer: issuer, mtls: mtls, policies: policies, keys: keys, logger: logger}
+	h.grants = map[string]grantHandler{
+		"authorization_code":   h.handleAuthorizationCodeGrant,
+		"refresh_token":        h.handleRefreshTokenGrant,
+		"client_credentials":   h.handleClientCredentialsGrant,
+		grantTypeDeviceCode:    h.handleDeviceCodeGrant,
+		grantTypeJWTBearer:     h.handleJWTBearerGrant,
+		grantTypeTokenExchange: h.handleTokenExchangeGrant,
+	}
+	return h, nil
 }
 
 func (h *Handler) HandleIndex(c echo.Context) error {
 	return c.JSON(http.StatusOK, "ok")
 }
 
+// authorizationParams holds the parameters of an authorization request,
+// whether they arrived in the query or were pushed beforehand.
+type authorizationParams struct {
+	ClientID            string
+	RedirectURI         string
+	ResponseType        string
+	ResponseMode        string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+	Prompt              string
+}
+
+func authorizationParamsFromValues(v url.Values) *authorizationParams {
+	return &authorizationParams{
+		ClientID:            v.Get("client_id"),
+		RedirectURI:         v.Get("redirect_uri"),
+		ResponseType:        v.Get("response_type"),
+		ResponseMode:        v.Get("response_mode"),
+		Scope:               v.Get("scope"),
+		State:               v.Get("state"),
+		CodeChallenge:       v.Get("code_challenge"),
+		CodeChallengeMethod: v.Get("code_challenge_method"),
+		Nonce:               v.Get("nonce"),
+		Prompt:              v.Get("prompt"),
+	}
+}
+
+func authorizationParamsFromRequest(req *model.AuthRequest, clientID string) *authorizationParams {
+	return &authorizationParams{
+		ClientID:            clientID,
+		RedirectURI:         req.RedirectURI,
+		ResponseType:        req.ResponseType,
+		ResponseMode:        req.ResponseMode,
+		Scope:               req.Scope,
+		State:               req.State,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Nonce:               req.Nonce,
+		Prompt:              req.Prompt,
+	}
+}
+
+type authorizationError struct {
+	code        string
+	description string
+}
+
+func (e *authorizationError) Error() string {
+	return e.description
+}
+
+// validateAuthorizationRequest checks the parameters against the client's
+// registration and fills in the normalized response type, the default
+// response mode and the default code challenge method.
+func validateAuthorizationRequest(client *model.Client, p *authorizationParams) error {
+	if p.RedirectURI == "" || p.ResponseType == "" {
+		return &authorizationError{"invalid_request", "invalid parameters"}
+	}
+	if !slices.Contains(client.RedirectURIs, p.RedirectURI) {
+		return &authorizationError{"invalid_request", "invalid redirect uri"}
+	}
+	p.ResponseType = normalizeResponseType(p.ResponseType)
+	if !slices.Contains(responseTypesSupported, p.ResponseType) {
+		return &authorizationError{"unsupported_response_type", "unsupported response type"}
+	}
+	if !isResponseTypeAllowed(client, p.ResponseType) {
+		return &authorizationError{"unauthorized_client", "response type not allowed for the client"}
+	}
+	if p.ResponseMode != "" && !slices.Contains(responseModesSupported, p.ResponseMode) {
+		return &authorizationError{"invalid_request", "unsupported response mode"}
+	}
+	p.ResponseMode = responseModeFor(p)
+	// tokens in the query end up in logs and Referer headers (OAuth 2.0
+	// Multiple Response Type Encoding Practices, section 2.1); signing the
+	// response does not hide them either (JARM section 2.3.1)
+	if (p.ResponseMode == responseModeQuery || p.ResponseMode == responseModeQueryJWT) && p.ResponseType != "code" {
+		return &authorizationError{"invalid_request", "the query response mode cannot return tokens"}
+	}
+	if err := validateScope(client, p.Scope); err != nil {
+		return &authorizationError{"invalid_scope", err.Error()}
+	}
+	// OpenID Connect Core section 3.2.2.1
+	if responseTypeHas(p.ResponseType, "id_token") {
+		if !hasScope(p.Scope, scopeOpenID) {
+			return &authorizationError{"invalid_request", "id_token requires the openid scope"}
+		}
+		if p.Nonce == "" {
+			return &authorizationError{"invalid_request", "nonce required"}
+		}
+	}
+
+	if p.CodeChallenge != "" {
+		if p.CodeChallengeMethod == "" {
+			p.CodeChallengeMethod = codeChallengeMethodPlain
+		}
+		if !isSupportedCodeChallengeMethod(p.CodeChallengeMethod) {
+			return &authorizationError{"invalid_request", "unsupported code challenge method"}
+		}
+		if !isValidCodeChallenge(p.CodeChallenge) {
+			return &authorizationError{"invalid_request", "invalid code challenge"}
+		}
+	} else if p.CodeChallengeMethod != "" {
+		return &authorizationError{"invalid_request", "code challenge required"}
+	} else if clientAuthMethod(client) == authMethodNone && responseTypeHas(p.ResponseType, "code") {
+		// RFC 9700 section 2.1.1
+		return &authorizationError{"invalid_request", "code challenge required for public clients"}
+	}
+
+	return nil
+}
+
 func (h *Handler) HandleAuthorize(c echo.Context) error {
 	q := c.Request().URL.Query()
 	clientID := q.Get("client_id")
-	redirectURI := q.Get("redirect_uri")
-	resType := q.Get("response_type")
-	scope := q.Get("scope")
-	state := q.Get("state")
+	requestURI := q.Get("request_uri")
 
-	if clientID == "" || redirectURI == "" || resType == "" {
+	if clientID == "" {
 		return c.Render(http.StatusBadRequest, "error.html", map[string]string{"error": "invalid parameters"})
 	}
 
@@ -62,129 +217,272 @@ func (h *Handler) HandleAuthorize(c echo.Context) error {
 		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "failed to get client"})
 	}
 
-	if !slices.Contains(client.RedirectURIs, redirectURI) {
-		return c.Render(http.StatusBadRequest, "error.html", map[string]string{"error": "invalid redirect uri"})
+	var pushed *model.AuthRequest
+	params := authorizationParamsFromValues(q)
+	switch {
+	case strings.HasPrefix(requestURI, requestURIPrefix):
+		pushed, err = h.findPushedRequest(requestURI, client)
+		if err != nil {
+			if errors.Is(err, errInvalidRequestURI) {
+				return c.Render(http.StatusBadRequest, "error.html", map[string]string{"error": "invalid request_uri"})
+			}
+			h.logger.Error("failed to get pushed request", zap.Error(err))
+			return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+		}
+		params = authorizationParamsFromRequest(pushed, clientID)
+	case client.RequirePushedAuthorizationRequests:
+		return c.Render(http.StatusBadRequest, "error.html", map[string]string{"error": "the client must use pushed authorization requests"})
+	case requestURI != "" || q.Has("request"):
+		values, err := h.resolveRequestObject(client, q)
+		if err != nil {
+			h.logger.Info("invalid request object", zap.Error(err))
+			return c.Render(http.StatusBadRequest, "error.html", map[string]string{"error": err.Error()})
+		}
+		params = authorizationParamsFromValues(values)
+	}
+
+	if err := validateAuthorizationRequest(client, params); err != nil {
+		var authErr *authorizationError
+		if errors.As(err, &authErr) && (authErr.code == "invalid_scope" || authErr.code == "unsupported_response_type") {
+			h.logger.Info("rejecting authorization request", zap.String("error", authErr.code), zap.String("reason", authErr.description))
+			return h.sendAuthorizationError(c, client, params.RedirectURI, responseModeFor(params), params.State, authErr.code)
+		}
+		return c.Render(http.StatusBadRequest, "error.html", map[string]string{"error": err.Error()})
+	}
+
+	sess, user, err := h.currentSession(c)
+	if err != nil {
+		if errors.Is(err, errNoSession) {
+			// the user comes back with the same request_uri, so it has to
+			// outlive a login or signup that takes longer than
+			// pushedRequestTTL; counted from the push, so that repeated
+			// visits cannot keep it alive
+			if pushed != nil {
+				if err := h.authRequestRepository.UpdateExpiry(pushed.ID, pushed.CreatedAt.Add(authRequestTTL)); err != nil {
+					h.logger.Error("failed to extend pushed request", zap.Error(err))
+					return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+				}
+			}
+			return redirectToLogin(c)
+		}
+		h.logger.Error("failed to get session", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+
+	// a request_uri can only be used once (RFC 9126 section 4), so it is
+	// only consumed here, after the login redirect has come back to us
+	if pushed != nil {
+		deleted, err := h.authRequestRepository.Delete(pushed.ID)
+		if err != nil {
+			h.logger.Error("failed to consume pushed request", zap.Error(err))
+			return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+		}
+		if !deleted {
+			return c.Render(http.StatusBadRequest, "error.html", map[string]string{"error": "invalid request_uri"})
+		}
 	}
 
 	req := &model.AuthRequest{
-		ClientID:     client.ID,
-		RedirectURI:  redirectURI,
-		ResponseType: resType,
-		State:        state,
-		Scope:        scope,
+		ClientID:            client.ID,
+		RedirectURI:         params.RedirectURI,
+		ResponseType:        params.ResponseType,
+		ResponseMode:        params.ResponseMode,
+		State:               params.State,
+		Scope:               params.Scope,
+		CodeChallenge:       params.CodeChallenge,
+		CodeChallengeMethod: params.CodeChallengeMethod,
+		Nonce:               params.Nonce,
+		Prompt:              params.Prompt,
+		UserID:              user.ID,
+		ExpiresAt:           time.Now().Add(authRequestTTL),
+	}
+
+	// OpenID Connect Core section 3.1.2.1
+	if !slices.Contains(strings.Fields(req.Prompt), "consent") {
+		consent, err := h.consentRepository.Find(user.ID, client.ID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			h.logger.Error("failed to get consent", zap.Error(err))
+			return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+		}
+		if err == nil && isScopeSubset(req.Scope, consent.Scope) {
+			return h.issueAuthorizationResponse(c, req, sess, user)
+		}
 	}
 
+	// only requests waiting on the consent page are saved, for HandleApprove
+	// to pick up
 	req, err = h.authRequestRepository.CreateRequest(*req)
 	if err != nil {
 		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "failed to save auth request"})
 	}
 
-	return c.Render(http.StatusOK, "approve.html", map[string]interface{}{"reqid": req.ID.String(), "client": client})
+	return c.Render(http.StatusOK, "approve.html", map[string]interface{}{"reqid": req.ID.String(), "client": client, "scopes": describeScopes(req.Scope), "user": user})
+}
+
+type tokenRequest struct {
+	GrantType    string `form:"grant_type"`
+	Code         string `form:"code"`
+	RedirectURI  string `form:"redirect_uri"`
+	Scope        string `form:"scope"`
+	CodeVerifier string `form:"code_verifier"`
+	RefreshToken string `form:"refresh_token"`
+	DeviceCode   string `form:"device_code"`
+	Assertion    string `form:"assertion"`
+
+	// RFC 8693 section 2.1
+	SubjectToken       string   `form:"subject_token"`
+	SubjectTokenType   string   `form:"subject_token_type"`
+	ActorToken         string   `form:"actor_token"`
+	ActorTokenType     string   `form:"actor_token_type"`
+	RequestedTokenType string   `form:"requested_token_type"`
+	Audience           []string `form:"audience"`
+	Resource           []string `form:"resource"`
+
+	// set from the DPoP proof of the request, not bound from the form
+	cnf *confirmation
 }
 
+type grantHandler func(c echo.Context, client *model.Client, body *tokenRequest) error
+
 func (h *Handler) HandleToken(c echo.Context) error {
-	header := c.Request().Header
-	auth := header.Get("Authorization")
-
-	var clientID string
-	var clientSecret string
-	if auth != "" {
-		h.logger.Debug("request has an authorization header", zap.ByteString("cred", []byte(strings.TrimPrefix(auth, "Basic "))))
-		decoded, err := base64.URLEncoding.DecodeString(strings.TrimPrefix(auth, "Basic "))
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, "internal server error")
+	client, err := h.authenticateClientRequest(c)
+	if err != nil {
+		if errors.Is(err, errCredentialsInQuery) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": err.Error()})
 		}
-		clientID = strings.Split(string(decoded), ":")[0]
-		clientSecret = strings.Split(string(decoded), ":")[1]
-	}
-	var body struct {
-		GrantType    string `form:"grant_type"`
-		Code         string `form:"code"`
-		ClinetID     string `form:"client_id"`
-		ClientSecret string `form:"client_secret"`
-		Scope        string `form:"scope"`
+		if errors.Is(err, errClientIDRequired) || errors.Is(err, errClientSecretRequired) {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		if errors.Is(err, errInvalidClient) {
+			return c.JSON(http.StatusForbidden, err.Error())
+		}
+		return c.JSON(http.StatusInternalServerError, "internal server error")
 	}
-	err := c.Bind(&body)
+
+	var body tokenRequest
+	err = c.Bind(&body)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, "internal server error")
 	}
-	h.logger.Debug("incoming request body", zap.Any("body", body))
+	h.logger.Debug("incoming token request", zap.String("grant_type", body.GrantType))
 
-	if clientID == "" {
-		if body.ClinetID == "" {
-			h.logger.Info("no clientid provided")
-			return c.JSON(http.StatusBadRequest, "client id required")
-		}
-		clientID = body.ClinetID
+	handle, ok := h.grants[body.GrantType]
+	if !ok {
+		h.logger.Info("unknown grant type")
+		return c.JSON(http.StatusBadRequest, "unknown grant type")
+	}
+	if !isGrantTypeAllowed(client, body.GrantType) {
+		h.logger.Info("grant type not allowed for the client", zap.String("grant_type", body.GrantType))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "unauthorized_client"})
 	}
 
-	if clientSecret == "" {
-		if body.ClientSecret == "" {
-			h.logger.Info("no client secret provided")
-			return c.JSON(http.StatusBadRequest, "client secret required")
+	body.cnf, err = h.verifyTokenRequestDPoP(c)
+	if err != nil {
+		if errors.Is(err, dpop.ErrInvalidProof) {
+			h.logger.Info("invalid DPoP proof", zap.Error(err))
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_dpop_proof", "error_description": err.Error()})
 		}
-		clientSecret = body.ClientSecret
+		h.logger.Error("failed to verify DPoP proof", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, "internal server error")
 	}
-
-	if clientID == "" || clientSecret == "" {
-		return c.JSON(http.StatusBadRequest, "invalid client")
+	if cert := clientCertificate(c); cert != nil && client.TLSClientCertificateBoundAccessTokens {
+		if body.cnf == nil {
+			body.cnf = &confirmation{}
+		}
+		body.cnf.X5TS256 = certificateThumbprint(cert)
 	}
 
-	client, err := h.clientRepository.FindClientByName(clientID)
+	return handle(c, client, &body)
+}
+
+func (h *Handler) handleAuthorizationCodeGrant(c echo.Context, client *model.Client, body *tokenRequest) error {
+	code, err := h.codeRepostiroy.FindByCode(body.Code)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return c.JSON(http.StatusForbidden, "invalid client ID or credential")
+			h.logger.Info("code not found")
+			return c.JSON(http.StatusBadRequest, "invalid code")
 		}
 		return c.JSON(http.StatusInternalServerError, "internal server error")
 	}
 
-	if client.Secret != clientSecret {
-		return c.JSON(http.StatusForbidden, "invalid client ID or credential")
+	// a replay has to revoke what the code issued even once the code has
+	// expired, so this comes before the expiry check
+	if code.UsedAt != nil {
+		return h.handleCodeReplay(c, code)
 	}
 
-	switch body.GrantType {
-	case "authorization_code":
-		code, err := h.codeRepostiroy.FindByCode(body.Code)
-		if err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				h.logger.Info("code not found", zap.String("code", body.Code))
-				return c.JSON(http.StatusBadRequest, "invalid code")
-			}
-			return c.JSON(http.StatusInternalServerError, "internal server error")
-		}
+	if time.Now().After(code.ExpiresAt) {
+		h.logger.Info("code expired", zap.String("code_id", code.ID.String()))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "code expired"})
+	}
 
-		// todo: change client name to client id because it's confusing
-		if client.Name != clientID {
-			h.logger.Info("invalid client id", zap.String("expected", code.ClientID.String()), zap.String("got", clientID))
-			return c.JSON(http.StatusBadRequest, "invalid client id")
-		}
+	if code.ClientID != client.ID {
+		h.logger.Info("code was issued to another client", zap.String("expected", code.ClientID.String()), zap.String("got", client.ID.String()))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "code was issued to another client"})
+	}
 
-		token, err := randutil.Alphanumeric(32)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, "internal server error")
-		}
+	// RFC 6749 section 4.1.3: the redirect_uri must be identical to the one
+	// the code was requested with
+	if body.RedirectURI != code.RedirectURI {
+		h.logger.Info("redirect uri does not match", zap.String("expected", code.RedirectURI), zap.String("got", body.RedirectURI))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "redirect uri does not match"})
+	}
 
-		t := model.Token{
-			Token:    token,
-			ClientID: client.ID,
-			Scope:    body.Scope,
+	if code.CodeChallenge != "" {
+		if body.CodeVerifier == "" {
+			h.logger.Info("no code verifier provided")
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "code verifier required"})
+		}
+		if !verifyCodeVerifier(body.CodeVerifier, code.CodeChallenge, code.CodeChallengeMethod) {
+			h.logger.Info("code verifier does not match the code challenge")
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "invalid code verifier"})
 		}
-		log.Print(t)
-		_, err = h.tokenRepository.Create(t)
+	} else if body.CodeVerifier != "" {
+		h.logger.Info("code verifier provided for a code issued without a challenge")
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "unexpected code verifier"})
+	}
+
+	scope, err := narrowScope(body.Scope, code.Scope)
+	if err != nil {
+		h.logger.Info("requested scope widens the grant", zap.String("requested", body.Scope), zap.String("granted", code.Scope))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_scope"})
+	}
+
+	consumed, err := h.codeRepostiroy.Consume(code.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+	if !consumed {
+		return h.handleCodeReplay(c, code)
+	}
+
+	// the code ID doubles as the token family, so a replayed code can revoke
+	// everything that was issued from it
+	res, err := h.issueTokens(client, code.Subject, scope, code.Scope, code.ID, body.cnf)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+
+	if hasScope(code.Scope, scopeOpenID) {
+		res.IDToken, err = h.signIDToken(client, code.Subject, code.Nonce, code.AuthTime, "", "")
 		if err != nil {
+			h.logger.Error("failed to sign id token", zap.Error(err))
 			return c.JSON(http.StatusInternalServerError, "internal server error")
 		}
+	}
 
-		return c.JSON(http.StatusOK, map[string]string{
-			"access_token": token,
-			"token_type":   "Bearer",
-			"scope":        body.Scope,
-		})
+	return c.JSON(http.StatusOK, res)
+}
 
-	default:
-		h.logger.Info("unknown grant type")
-		return c.JSON(http.StatusBadRequest, "unknown grant type")
+// handleCodeReplay revokes every token issued from a code that is redeemed a
+// second time, as RFC 6749 section 10.5 requires.
+func (h *Handler) handleCodeReplay(c echo.Context, code *model.AuthCode) error {
+	h.logger.Warn("authorization code replay detected, revoking issued tokens", zap.String("code_id", code.ID.String()))
+	if err := h.revokeFamily(code.ID); err != nil {
+		h.logger.Error("failed to revoke token family", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, "internal server error")
 	}
+	return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "code already used"})
 }
 
 func (h *Handler) HandleApprove(c echo.Context) error {
@@ -203,6 +501,15 @@ func (h *Handler) HandleApprove(c echo.Context) error {
 		return c.Render(http.StatusBadRequest, "error.html", map[string]string{"error": "invalid parameters"})
 	}
 
+	sess, user, err := h.currentSession(c)
+	if err != nil {
+		if errors.Is(err, errNoSession) {
+			return c.Render(http.StatusUnauthorized, "error.html", map[string]string{"error": "login required"})
+		}
+		h.logger.Error("failed to get session", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+
 	req, err := h.authRequestRepository.FindRequestByID(b.ReqID)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -211,55 +518,106 @@ func (h *Handler) HandleApprove(c echo.Context) error {
 		h.logger.Error("failed to get request id", zap.Error(err))
 		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "invalid request id"})
 	}
+	// the request can only be decided by the user it was shown to
+	if req.Pushed || req.UserID != user.ID || time.Now().After(req.ExpiresAt) {
+		return c.Render(http.StatusBadRequest, "error.html", map[string]string{"error": "invalid request id"})
+	}
 
-	if b.Approve != "Approve" {
-		u, err := url.Parse(req.RedirectURI)
-		if err != nil {
-			return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
-		}
-		q := u.Query()
-		q.Add("error", "access_denied")
-		u.RawQuery = q.Encode()
-
-		return c.Redirect(http.StatusSeeOther, u.String())
+	// a request is decided once, so that re-posting the form cannot mint
+	// another code
+	deleted, err := h.authRequestRepository.Delete(req.ID)
+	if err != nil {
+		h.logger.Error("failed to consume request", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+	if !deleted {
+		return c.Render(http.StatusBadRequest, "error.html", map[string]string{"error": "invalid request id"})
 	}
 
-	if req.ResponseType != "code" {
-		u, err := url.Parse(req.RedirectURI)
+	if b.Approve != "Approve" {
+		client, err := h.clientRepository.FindClientByID(req.ClientID.String())
 		if err != nil {
+			h.logger.Error("failed to get client", zap.Error(err))
 			return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
 		}
-		q := u.Query()
-		q.Add("error", "unsupported_response_type")
-		u.RawQuery = q.Encode()
+		return h.sendAuthorizationError(c, client, req.RedirectURI, req.ResponseMode, req.State, "access_denied")
+	}
 
-		return c.Redirect(http.StatusSeeOther, u.String())
+	if err := h.saveConsent(user, req); err != nil {
+		h.logger.Error("failed to save consent", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
 	}
 
-	codeStr, err := randutil.Alphanumeric(8)
+	return h.issueAuthorizationResponse(c, req, sess, user)
+}
+
+func (h *Handler) issueAuthorizationResponse(c echo.Context, req *model.AuthRequest, sess *model.Session, user *model.User) error {
+	client, err := h.clientRepository.FindClientByID(req.ClientID.String())
 	if err != nil {
 		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
 	}
-	code := &model.AuthCode{
-		Code:     codeStr,
-		Scope:    req.Scope,
-		ClientID: req.ClientID,
+
+	params := url.Values{}
+	// tokens issued from the authorization endpoint belong to the same grant
+	// as the code, so revoking one revokes the others
+	familyID := uuid.New()
+
+	var codeStr string
+	if responseTypeHas(req.ResponseType, "code") {
+		codeStr, err = randutil.Alphanumeric(authCodeLength)
+		if err != nil {
+			return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+		}
+		code := &model.AuthCode{
+			Code:                codeStr,
+			Subject:             user.ID.String(),
+			Scope:               req.Scope,
+			ClientID:            req.ClientID,
+			RedirectURI:         req.RedirectURI,
+			CodeChallenge:       req.CodeChallenge,
+			CodeChallengeMethod: req.CodeChallengeMethod,
+			Nonce:               req.Nonce,
+			AuthTime:            sess.AuthTime,
+			ExpiresAt:           time.Now().Add(authCodeTTL),
+		}
+		created, err := h.codeRepostiroy.Create(*code)
+		if err != nil {
+			return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+		}
+		familyID = created.ID
+		params.Set("code", codeStr)
 	}
-	_, err = h.codeRepostiroy.Create(*code)
-	if err != nil {
-		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+
+	var accessToken string
+	if responseTypeHas(req.ResponseType, "token") {
+		at, err := h.issueAccessToken(client, user.ID.String(), req.Scope, familyID, nil)
+		if err != nil {
+			return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+		}
+		accessToken = at.Token
+		params.Set("access_token", at.Token)
+		params.Set("token_type", tokenType(at))
+		params.Set("expires_in", strconv.FormatInt(int64(accessTokenTTL.Seconds()), 10))
+		params.Set("scope", at.Scope)
 	}
 
-	url, err := url.Parse(req.RedirectURI)
-	if err != nil {
-		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	if responseTypeHas(req.ResponseType, "id_token") {
+		idToken, err := h.signIDToken(client, user.ID.String(), req.Nonce, sess.AuthTime, accessToken, codeStr)
+		if err != nil {
+			return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+		}
+		params.Set("id_token", idToken)
 	}
-	q := url.Query()
-	q.Add("code", codeStr)
-	q.Add("state", req.State)
-	url.RawQuery = q.Encode()
 
-	return c.Redirect(http.StatusSeeOther, url.String())
+	params.Set("state", req.State)
+
+	return h.sendAuthorizationResponse(c, client, req.RedirectURI, req.ResponseMode, params)
+}
+
+// isGrantTypeAllowed treats clients without registered grant types, such as
+// the ones created by hand in dev.db, as allowed to use every grant.
+func isGrantTypeAllowed(client *model.Client, grantType string) bool {
+	return len(client.GrantTypes) == 0 || slices.Contains(client.GrantTypes, grantType)
 }
 
 func (h *Handler) getClient(clientID string) (*model.Client, error) {
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/voice0726/oauth-playground/model"
+)
+
+var reqIDPattern = regexp.MustCompile(`name="reqid" value="([^"]+)"`)
+
+// openConsentPage starts an authorization request as the user of session and
+// returns the ID of the request waiting on the consent page.
+func (s *testServer) openConsentPage(t *testing.T, client *model.Client, session *http.Cookie) string {
+	t.Helper()
+	q := url.Values{
+		"client_id":     {client.Name},
+		"redirect_uri":  {client.RedirectURIs[0]},
+		"response_type": {"code"},
+		"scope":         {"profile"},
+		"state":         {"state"},
+		// otherwise a consent remembered by an earlier test skips the page
+		"prompt": {"consent"},
+	}
+	rec := s.get("/authorize?"+q.Encode(), session)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("authorize status = %d: %s", rec.Code, rec.Body)
+	}
+	m := reqIDPattern.FindStringSubmatch(rec.Body.String())
+	if m == nil {
+		t.Fatalf("no reqid on the consent page: %s", rec.Body)
+	}
+	return m[1]
+}
+
+func TestHandleApprove(t *testing.T) {
+	s := newTestServer(t)
+	client := s.createClient(t, model.Client{})
+	_, session := s.login(t)
+	_, otherSession := s.login(t)
+
+	t.Run("approves once", func(t *testing.T) {
+		reqID := s.openConsentPage(t, client, session)
+		form := url.Values{"reqid": {reqID}, "approve": {"Approve"}}
+
+		params := redirectParams(t, s.postForm("/approve", form, "", "", session))
+		if params.Get("code") == "" || params.Get("state") != "state" {
+			t.Fatalf("unexpected response: %v", params)
+		}
+
+		rec := s.postForm("/approve", form, "", "", session)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("replayed approve status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("denies once", func(t *testing.T) {
+		reqID := s.openConsentPage(t, client, session)
+		form := url.Values{"reqid": {reqID}, "deny": {"Deny"}}
+
+		params := redirectParams(t, s.postForm("/approve", form, "", "", session))
+		if params.Get("error") != "access_denied" {
+			t.Fatalf("unexpected response: %v", params)
+		}
+
+		form.Set("approve", "Approve")
+		rec := s.postForm("/approve", form, "", "", session)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("approve after deny status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("rejects another user", func(t *testing.T) {
+		reqID := s.openConsentPage(t, client, session)
+		form := url.Values{"reqid": {reqID}, "approve": {"Approve"}}
+
+		rec := s.postForm("/approve", form, "", "", otherSession)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+		// the request is still there for the user it was shown to
+		params := redirectParams(t, s.postForm("/approve", form, "", "", session))
+		if params.Get("code") == "" {
+			t.Fatalf("unexpected response: %v", params)
+		}
+	})
+
+	t.Run("requires a session", func(t *testing.T) {
+		reqID := s.openConsentPage(t, client, session)
+		rec := s.postForm("/approve", url.Values{"reqid": {reqID}, "approve": {"Approve"}}, "", "")
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+		}
+	})
+}
+
+func TestAuthorizationCodeReplay(t *testing.T) {
+	s := newTestServer(t)
+	client := s.createClient(t, model.Client{})
+
+	assertRevoked := func(t *testing.T, res *tokenResponse) {
+		t.Helper()
+		at, err := s.h.tokenRepository.FindByToken(res.AccessToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isAccessTokenActive(at) {
+			t.Error("access token is still active")
+		}
+		rt, err := s.h.refreshTokenRepository.FindByToken(res.RefreshToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isRefreshTokenUsable(rt) {
+			t.Error("refresh token is still usable")
+		}
+	}
+
+	t.Run("revokes the token family", func(t *testing.T) {
+		code := s.issueCode(t, client, "user", "profile")
+		first := s.redeemCode(t, client, code, nil)
+		// tokens issued from the refresh token belong to the family too
+		refreshed := decodeTokenResponse(t, s.tokenRequest(t, client, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {first.RefreshToken}}, nil))
+
+		form := url.Values{"grant_type": {"authorization_code"}, "code": {code.Code}, "redirect_uri": {code.RedirectURI}}
+		if rec := s.tokenRequest(t, client, form, nil); tokenError(t, rec) != "invalid_grant" {
+			t.Fatalf("replay: %s", rec.Body)
+		}
+		assertRevoked(t, first)
+		assertRevoked(t, refreshed)
+	})
+
+	t.Run("revokes after the code has expired", func(t *testing.T) {
+		// a code that was redeemed and has expired since
+		code := s.issueCode(t, client, "user", "profile", func(code *model.AuthCode) {
+			usedAt := time.Now().Add(-2 * authCodeTTL)
+			code.UsedAt = &usedAt
+			code.ExpiresAt = time.Now().Add(-authCodeTTL)
+		})
+		res, err := s.h.issueTokens(client, code.Subject, code.Scope, code.Scope, code.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		form := url.Values{"grant_type": {"authorization_code"}, "code": {code.Code}, "redirect_uri": {code.RedirectURI}}
+		if rec := s.tokenRequest(t, client, form, nil); tokenError(t, rec) != "invalid_grant" {
+			t.Fatalf("replay: %s", rec.Body)
+		}
+		assertRevoked(t, res)
+	})
+
+	t.Run("rejects an expired code", func(t *testing.T) {
+		code := s.issueCode(t, client, "user", "profile", func(code *model.AuthCode) {
+			code.ExpiresAt = time.Now().Add(-time.Second)
+		})
+
+		form := url.Values{"grant_type": {"authorization_code"}, "code": {code.Code}, "redirect_uri": {code.RedirectURI}}
+		if rec := s.tokenRequest(t, client, form, nil); tokenError(t, rec) != "invalid_grant" {
+			t.Fatalf("expired code: %s", rec.Body)
+		}
+		used, err := s.h.codeRepostiroy.FindByID(code.ID.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if used.UsedAt != nil {
+			t.Error("expired code was consumed")
+		}
+	})
+}
+
+func TestAuthorizationCodeBinding(t *testing.T) {
+	s := newTestServer(t)
+	client := s.createClient(t, model.Client{RedirectURIs: []string{"https://client.example/callback", "https://client.example/other"}})
+	otherClient := s.createClient(t, model.Client{})
+	verifier := strings.Repeat("v", 43)
+
+	tests := []struct {
+		name    string
+		client  *model.Client
+		form    url.Values
+		wantErr string
+	}{
+		{name: "another client", client: otherClient, wantErr: "invalid_grant"},
+		{name: "another redirect uri", client: client, form: url.Values{"redirect_uri": {"https://client.example/other"}}, wantErr: "invalid_grant"},
+		{name: "no redirect uri", client: client, form: url.Values{"redirect_uri": {""}}, wantErr: "invalid_grant"},
+		{name: "no code verifier", client: client, form: url.Values{"code_verifier": {""}}, wantErr: "invalid_grant"},
+		{name: "wrong code verifier", client: client, form: url.Values{"code_verifier": {strings.Repeat("w", 43)}}, wantErr: "invalid_grant"},
+		{name: "valid", client: client},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := s.issueCode(t, client, "user", "profile", func(code *model.AuthCode) {
+				code.CodeChallenge = verifier
+				code.CodeChallengeMethod = codeChallengeMethodPlain
+			})
+			form := url.Values{
+				"grant_type":    {"authorization_code"},
+				"code":          {code.Code},
+				"redirect_uri":  {code.RedirectURI},
+				"code_verifier": {verifier},
+			}
+			for k, v := range tt.form {
+				form[k] = v
+			}
+
+			rec := s.tokenRequest(t, tt.client, form, nil)
+			if tt.wantErr == "" {
+				decodeTokenResponse(t, rec)
+				return
+			}
+			if got := tokenError(t, rec); got != tt.wantErr {
+				t.Fatalf("error = %q, want %q", got, tt.wantErr)
+			}
+			// a rejected attempt does not use up the code
+			if used, err := s.h.codeRepostiroy.FindByID(code.ID.String()); err != nil || used.UsedAt != nil {
+				t.Errorf("code was consumed by a rejected request")
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type introspectionResponse struct {
+	Active    bool          `json:"active"`
+	Scope     string        `json:"scope,omitempty"`
+	ClientID  string        `json:"client_id,omitempty"`
+	Exp       int64         `json:"exp,omitempty"`
+	Iat       int64         `json:"iat,omitempty"`
+	Sub       string        `json:"sub,omitempty"`
+	Aud       []string      `json:"aud,omitempty"`
+	TokenType string        `json:"token_type,omitempty"`
+	Cnf       *confirmation `json:"cnf,omitempty"`
+	Act       *actor        `json:"act,omitempty"`
+}
+
+func (h *Handler) HandleIntrospect(c echo.Context) error {
+	client, err := h.authenticateClientRequest(c)
+	if err != nil {
+		if errors.Is(err, errCredentialsInQuery) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": err.Error()})
+		}
+		if isClientAuthenticationError(err) {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		}
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+	// public clients could otherwise probe any token
+	if clientAuthMethod(client) == authMethodNone {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+	}
+
+	token := c.FormValue("token")
+	if token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "token required"})
+	}
+
+	var res *introspectionResponse
+	if c.FormValue("token_type_hint") == "refresh_token" {
+		res, err = h.introspectRefreshToken(token)
+		if err == nil && !res.Active {
+			res, err = h.introspectAccessToken(token)
+		}
+	} else {
+		res, err = h.introspectAccessToken(token)
+		if err == nil && !res.Active {
+			res, err = h.introspectRefreshToken(token)
+		}
+	}
+	if err != nil {
+		h.logger.Error("failed to introspect token", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) introspectAccessToken(token string) (*introspectionResponse, error) {
+	t, err := h.tokenRepository.FindByToken(token)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &introspectionResponse{Active: false}, nil
+		}
+		return nil, err
+	}
+
+	if !isAccessTokenActive(t) {
+		return &introspectionResponse{Active: false}, nil
+	}
+
+	client, err := h.clientRepository.FindClientByID(t.ClientID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return &introspectionResponse{
+		Active:    true,
+		Scope:     t.Scope,
+		ClientID:  client.Name,
+		Exp:       t.ExpiresAt.Unix(),
+		Iat:       t.CreatedAt.Unix(),
+		Sub:       t.Subject,
+		Aud:       t.Audience,
+		TokenType: tokenType(t),
+		Cnf:       tokenConfirmation(t),
+		Act:       tokenActor(t),
+	}, nil
+}
+
+func (h *Handler) introspectRefreshToken(token string) (*introspectionResponse, error) {
+	t, err := h.refreshTokenRepository.FindByToken(token)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &introspectionResponse{Active: false}, nil
+		}
+		return nil, err
+	}
+
+	if t.Rotated || !isRefreshTokenUsable(t) {
+		return &introspectionResponse{Active: false}, nil
+	}
+
+	client, err := h.clientRepository.FindClientByID(t.ClientID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return &introspectionResponse{
+		Active:    true,
+		Scope:     t.Scope,
+		ClientID:  client.Name,
+		Exp:       t.ExpiresAt.Unix(),
+		Iat:       t.CreatedAt.Unix(),
+		Sub:       t.Subject,
+		TokenType: "refresh_token",
+	}, nil
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/voice0726/oauth-playground/model"
+)
+
+func TestHandleIntrospect(t *testing.T) {
+	s := newTestServer(t)
+	client := s.createClient(t, model.Client{})
+	resourceServer := s.createClient(t, model.Client{})
+	public := s.createClient(t, model.Client{TokenEndpointAuthMethod: authMethodNone})
+
+	res := s.redeemCode(t, client, s.issueCode(t, client, "user", "openid profile"), nil)
+	rotated := res.RefreshToken
+	res = decodeTokenResponse(t, s.tokenRequest(t, client, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {rotated}}, nil))
+
+	introspect := func(c *model.Client, token, hint string) *introspectionResponse {
+		t.Helper()
+		form := url.Values{"token": {token}}
+		if hint != "" {
+			form.Set("token_type_hint", hint)
+		}
+		rec := s.postForm("/introspect", form, c.Name, c.Secret)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("introspect status = %d: %s", rec.Code, rec.Body)
+		}
+		var ir introspectionResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &ir); err != nil {
+			t.Fatal(err)
+		}
+		return &ir
+	}
+
+	ir := introspect(resourceServer, res.AccessToken, "")
+	if !ir.Active || ir.Sub != "user" || ir.ClientID != client.Name || ir.Scope != "openid profile" || ir.TokenType != "Bearer" {
+		t.Errorf("access token: %+v", ir)
+	}
+	// the hint is only where the lookup starts
+	if ir := introspect(resourceServer, res.AccessToken, "refresh_token"); !ir.Active {
+		t.Error("access token with a refresh_token hint is inactive")
+	}
+	if ir := introspect(resourceServer, res.RefreshToken, ""); !ir.Active || ir.ClientID != client.Name {
+		t.Errorf("refresh token: %+v", ir)
+	}
+	if ir := introspect(resourceServer, rotated, "refresh_token"); ir.Active {
+		t.Error("rotated refresh token is active")
+	}
+	if ir := introspect(resourceServer, "unknown", ""); ir.Active || ir.Sub != "" {
+		t.Errorf("unknown token: %+v", ir)
+	}
+
+	rec := s.postForm("/introspect", url.Values{"token": {res.AccessToken}, "client_id": {public.Name}}, "", "")
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("public client: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	rec = s.postForm("/introspect", url.Values{"token": {res.AccessToken}}, client.Name, "wrong")
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
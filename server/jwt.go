@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/voice0726/oauth-playground/model"
+	"go.step.sm/crypto/jose"
+)
+
+// RFC 9068 section 2.1
+const accessTokenJWTType = "at+jwt"
+
+type accessTokenClaims struct {
+	jose.Claims
+	ClientID string        `json:"client_id"`
+	Scope    string        `json:"scope,omitempty"`
+	Cnf      *confirmation `json:"cnf,omitempty"`
+	Act      *actor        `json:"act,omitempty"`
+}
+
+func (h *Handler) signAccessToken(client *model.Client, t *model.Token, issuedAt time.Time) (string, error) {
+	// tokens without a resource owner, such as client credentials, are about
+	// the client itself (RFC 9068 section 2.2)
+	subject := t.Subject
+	if subject == "" {
+		subject = client.Name
+	}
+	audience := jose.Audience{h.issuer}
+	if len(t.Audience) > 0 {
+		audience = jose.Audience(t.Audience)
+	}
+	claims := accessTokenClaims{
+		Claims: jose.Claims{
+			Issuer:   h.issuer,
+			Subject:  subject,
+			Audience: audience,
+			Expiry:   jose.NewNumericDate(t.ExpiresAt),
+			IssuedAt: jose.NewNumericDate(issuedAt),
+			ID:       uuid.NewString(),
+		},
+		ClientID: client.Name,
+		Scope:    t.Scope,
+		Cnf:      tokenConfirmation(t),
+		Act:      tokenActor(t),
+	}
+	return h.keys.sign(claims, accessTokenJWTType)
+}
+
+func (h *Handler) HandleJWKS(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.keys.publicKeySet())
+}
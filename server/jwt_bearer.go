@@ -0,0 +1,139 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/voice0726/oauth-playground/model"
+	"go.step.sm/crypto/jose"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const grantTypeJWTBearer = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+var (
+	errInvalidGrantAssertion  = errors.New("invalid assertion")
+	errUntrustedIssuer        = fmt.Errorf("%w: issuer is not trusted by the client", errInvalidGrantAssertion)
+	errReplayedGrantAssertion = fmt.Errorf("%w: assertion has already been used", errInvalidGrantAssertion)
+	errUnknownSubject         = fmt.Errorf("%w: subject does not match a user", errInvalidGrantAssertion)
+)
+
+// handleJWTBearerGrant exchanges a JWT from an issuer the client trusts for
+// an access token on behalf of the user it names (RFC 7523 section 2.1).
+func (h *Handler) handleJWTBearerGrant(c echo.Context, client *model.Client, body *tokenRequest) error {
+	if body.Assertion == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "assertion required"})
+	}
+
+	user, err := h.verifyGrantAssertion(c, client, body.Assertion)
+	if err != nil {
+		if errors.Is(err, errInvalidGrantAssertion) {
+			h.logger.Info("invalid jwt-bearer assertion", zap.Error(err))
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": err.Error()})
+		}
+		h.logger.Error("failed to verify jwt-bearer assertion", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+
+	if err := validateScope(client, body.Scope); err != nil {
+		h.logger.Info("requested scope is not allowed for the client", zap.String("scope", body.Scope))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_scope"})
+	}
+	scope, _ := narrowScope(body.Scope, strings.Join(allowedScopes(client), " "))
+
+	// the assertion stands in for the user's authorization, so there is no
+	// refresh token; the client presents a fresh assertion instead
+	at, err := h.issueAccessToken(client, user.ID.String(), scope, uuid.New(), body.cnf)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+
+	return c.JSON(http.StatusOK, &tokenResponse{
+		AccessToken: at.Token,
+		TokenType:   tokenType(at),
+		ExpiresIn:   int64(accessTokenTTL.Seconds()),
+		Scope:       at.Scope,
+	})
+}
+
+// verifyGrantAssertion checks the assertion against the issuer it names,
+// which the operator must have configured as trusted for the client (RFC 7523
+// section 3) and returns the user its sub identifies. The sub must be our own
+// subject identifier, the user ID; usernames are chosen by users and could
+// be set to another user's ID.
+func (h *Handler) verifyGrantAssertion(c echo.Context, client *model.Client, assertion string) (*model.User, error) {
+	tok, err := jose.ParseSigned(assertion)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidGrantAssertion, err)
+	}
+	var unverified jose.Claims
+	if err := tok.UnsafeClaimsWithoutVerification(&unverified); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidGrantAssertion, err)
+	}
+	trusted := h.clientPolicy(client).JWTBearerIssuers
+	i := slices.IndexFunc(trusted, func(ti TrustedIssuer) bool {
+		return ti.Issuer == unverified.Issuer
+	})
+	if unverified.Issuer == "" || i < 0 {
+		return nil, errUntrustedIssuer
+	}
+	issuer := trusted[i]
+
+	keys := issuer.JWKS
+	if keys == nil {
+		keys, err = h.keySet("", issuer.JWKSURI)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to get the keys of the issuer: %w", errInvalidGrantAssertion, err)
+		}
+	}
+	var claims jose.Claims
+	if err := verifyJWT(keys, assertion, &claims); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidGrantAssertion, err)
+	}
+
+	expected := jose.Expected{
+		Issuer: issuer.Issuer,
+		Time:   time.Now(),
+	}
+	if err := claims.ValidateWithLeeway(expected, time.Minute); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidGrantAssertion, err)
+	}
+	if claims.Subject == "" || claims.Expiry == nil {
+		return nil, fmt.Errorf("%w: sub and exp are required", errInvalidGrantAssertion)
+	}
+	audiences := []string{h.issuer, h.issuer + "/token", h.baseURL(c) + c.Request().URL.Path}
+	if !slices.ContainsFunc(audiences, claims.Audience.Contains) {
+		return nil, fmt.Errorf("%w: unexpected audience", errInvalidGrantAssertion)
+	}
+
+	// jti is optional here, but an assertion that has one is used only once
+	if claims.ID != "" {
+		fresh, err := h.jtiRepository.Record("jwt_bearer:"+issuer.Issuer+":"+claims.ID, claims.Expiry.Time().Add(time.Minute))
+		if err != nil {
+			return nil, err
+		}
+		if !fresh {
+			return nil, errReplayedGrantAssertion
+		}
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, errUnknownSubject
+	}
+	user, err := h.userRepository.FindByID(userID.String())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errUnknownSubject
+		}
+		return nil, err
+	}
+	return user, nil
+}
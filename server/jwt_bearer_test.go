@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/voice0726/oauth-playground/model"
+	"go.step.sm/crypto/jose"
+)
+
+func TestJWTBearerGrant(t *testing.T) {
+	issuerKey, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unavailable := httptest.NewServer(http.NotFoundHandler())
+	defer unavailable.Close()
+
+	const clientID = "jwt-bearer-client"
+	s := newTestServer(t, func(h *Handler) {
+		h.policies = map[string]ClientPolicy{clientID: {JWTBearerIssuers: []TrustedIssuer{
+			{Issuer: "https://idp.example", JWKS: &jose.JSONWebKeySet{Keys: []jose.JSONWebKey{issuerKey.Public()}}},
+			{Issuer: "https://unavailable.example", JWKSURI: unavailable.URL + "/jwks"},
+		}}}
+	})
+	client := s.createClient(t, model.Client{Name: clientID, GrantTypes: []string{grantTypeJWTBearer}})
+	user, _ := s.login(t)
+	// a user whose username is the ID of another user
+	if _, err := s.h.userRepository.Create(model.User{Username: user.ID.String()}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertion := func(issuer, subject string) string {
+		return signAssertion(t, jose.ES256, issuerKey, jose.Claims{
+			Issuer:   issuer,
+			Subject:  subject,
+			Audience: jose.Audience{testIssuer + "/token"},
+			Expiry:   jose.NewNumericDate(time.Now().Add(time.Minute)),
+		})
+	}
+	request := func(assertion string) *httptest.ResponseRecorder {
+		return s.tokenRequest(t, client, url.Values{"grant_type": {grantTypeJWTBearer}, "assertion": {assertion}}, nil)
+	}
+
+	res := decodeTokenResponse(t, request(assertion("https://idp.example", user.ID.String())))
+	token, err := s.h.tokenRepository.FindByToken(res.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Subject != user.ID.String() {
+		t.Errorf("subject = %q, want %q", token.Subject, user.ID)
+	}
+
+	tests := []struct {
+		name      string
+		assertion string
+	}{
+		{name: "username as sub", assertion: assertion("https://idp.example", user.Username)},
+		{name: "unknown sub", assertion: assertion("https://idp.example", "00000000-0000-0000-0000-000000000000")},
+		{name: "untrusted issuer", assertion: assertion("https://other.example", user.ID.String())},
+		{name: "unavailable keys", assertion: assertion("https://unavailable.example", user.ID.String())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := request(tt.assertion)
+			if rec.Code != http.StatusBadRequest || tokenError(t, rec) != "invalid_grant" {
+				t.Errorf("status = %d, body = %s", rec.Code, rec.Body)
+			}
+		})
+	}
+}
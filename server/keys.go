@@ -0,0 +1,35 @@
+package server
+
+import (
+	"go.step.sm/crypto/jose"
+)
+
+// keySet holds the key the server signs its JWTs with. The key is generated on
+// start-up, so tokens signed by a previous run stop validating after a restart.
+type keySet struct {
+	signingKey *jose.JSONWebKey
+}
+
+func newKeySet() (*keySet, error) {
+	jwk, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	if err != nil {
+		return nil, err
+	}
+	return &keySet{signingKey: jwk}, nil
+}
+
+func (k *keySet) publicKeySet() jose.JSONWebKeySet {
+	return jose.JSONWebKeySet{Keys: []jose.JSONWebKey{k.signingKey.Public()}}
+}
+
+// sign serializes claims as a compact JWS with the given typ header.
+func (k *keySet) sign(claims interface{}, typ jose.ContentType) (string, error) {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.ES256, Key: k.signingKey},
+		(&jose.SignerOptions{}).WithType(typ),
+	)
+	if err != nil {
+		return "", err
+	}
+	return jose.Signed(signer).Claims(claims).CompactSerialize()
+}
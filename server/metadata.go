@@ -0,0 +1,121 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+	"github.com/voice0726/oauth-playground/dpop"
+)
+
+var (
+	// normalized as by normalizeResponseType
+	responseTypesSupported = []string{"code", "token", "id_token", "code id_token", "code token", "id_token token", "code id_token token"}
+	responseModesSupported = []string{
+		responseModeQuery, responseModeFragment, responseModeFormPost,
+		responseModeJWT, responseModeQueryJWT, responseModeFragmentJWT, responseModeFormPostJWT,
+	}
+)
+
+// metadataEndpoints maps the routes registered in initializeRoutes to their
+// RFC 8414 / OpenID Connect Discovery metadata names.
+var metadataEndpoints = map[string]string{
+	"/authorize":             "authorization_endpoint",
+	"/par":                   "pushed_authorization_request_endpoint",
+	"/token":                 "token_endpoint",
+	"/.well-known/jwks.json": "jwks_uri",
+	"/introspect":            "introspection_endpoint",
+	"/revoke":                "revocation_endpoint",
+	"/userinfo":              "userinfo_endpoint",
+	"/device_authorization":  "device_authorization_endpoint",
+	"/register":              "registration_endpoint",
+}
+
+func (h *Handler) HandleAuthorizationServerMetadata(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.metadata(c.Echo()))
+}
+
+func (h *Handler) HandleOpenIDConfiguration(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.metadata(c.Echo()))
+}
+
+func (h *Handler) metadata(e *echo.Echo) map[string]interface{} {
+	md := map[string]interface{}{
+		"issuer":                                           h.issuer,
+		"response_types_supported":                         responseTypesSupported,
+		"response_modes_supported":                         responseModesSupported,
+		"grant_types_supported":                            h.grantTypesSupported(),
+		"token_endpoint_auth_methods_supported":            h.tokenEndpointAuthMethods(),
+		"scopes_supported":                                 scopesSupported(),
+		"code_challenge_methods_supported":                 codeChallengeMethodsSupported,
+		"dpop_signing_alg_values_supported":                dpop.SigningAlgs,
+		"token_endpoint_auth_signing_alg_values_supported": append(slices.Clone(clientSecretSigningAlgsSupported), clientSigningAlgsSupported...),
+		"authorization_signing_alg_values_supported":       []string{"ES256"},
+		"authorization_response_iss_parameter_supported":   true,
+	}
+
+	for _, r := range e.Routes() {
+		if name, ok := metadataEndpoints[r.Path]; ok {
+			md[name] = h.issuer + r.Path
+		}
+	}
+
+	if _, ok := md["introspection_endpoint"]; ok {
+		md["introspection_endpoint_auth_methods_supported"] = slices.DeleteFunc(h.tokenEndpointAuthMethods(), func(m string) bool {
+			return m == authMethodNone
+		})
+	}
+	if _, ok := md["revocation_endpoint"]; ok {
+		md["revocation_endpoint_auth_methods_supported"] = h.tokenEndpointAuthMethods()
+	}
+	if _, ok := md["authorization_endpoint"]; ok {
+		md["request_parameter_supported"] = true
+		md["request_uri_parameter_supported"] = true
+		md["require_request_uri_registration"] = true
+		md["request_object_signing_alg_values_supported"] = clientSigningAlgsSupported
+	}
+	if _, ok := md["pushed_authorization_request_endpoint"]; ok {
+		md["require_pushed_authorization_requests"] = false
+	}
+	if h.mtls != nil {
+		aliases := map[string]string{}
+		for _, r := range e.Routes() {
+			if name, ok := metadataEndpoints[r.Path]; ok && slices.Contains(mtlsEndpoints, name) {
+				aliases[name] = h.mtls.BaseURL + r.Path
+			}
+		}
+		md["mtls_endpoint_aliases"] = aliases
+		md["tls_client_certificate_bound_access_tokens"] = true
+	}
+	if _, ok := md["userinfo_endpoint"]; ok {
+		md["subject_types_supported"] = []string{"public"}
+		md["id_token_signing_alg_values_supported"] = []string{"ES256"}
+		md["claims_supported"] = claimsSupported()
+	}
+
+	return md
+}
+
+func (h *Handler) grantTypesSupported() []string {
+	grantTypes := make([]string, 0, len(h.grants))
+	for gt := range h.grants {
+		grantTypes = append(grantTypes, gt)
+	}
+	grantTypes = append(grantTypes, grantTypeImplicit)
+	sort.Strings(grantTypes)
+	return grantTypes
+}
+
+func claimsSupported() []string {
+	claims := []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce"}
+	for _, names := range scopeClaims {
+		for _, name := range names {
+			if !slices.Contains(claims, name) {
+				claims = append(claims, name)
+			}
+		}
+	}
+	sort.Strings(claims)
+	return claims
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMetadataMTLS(t *testing.T) {
+	s := newTestServer(t)
+
+	authMethods := func(md map[string]interface{}) []string {
+		return md["token_endpoint_auth_methods_supported"].([]string)
+	}
+
+	md := s.h.metadata(s.e)
+	if slices.ContainsFunc(authMethods(md), isMTLSAuthMethod) {
+		t.Errorf("mutual-TLS auth methods advertised without mTLS: %v", authMethods(md))
+	}
+	for _, name := range []string{"tls_client_certificate_bound_access_tokens", "mtls_endpoint_aliases"} {
+		if _, ok := md[name]; ok {
+			t.Errorf("%s advertised without mTLS", name)
+		}
+	}
+
+	s.h.mtls = &MTLSConfig{BaseURL: "https://mtls.as.example"}
+	md = s.h.metadata(s.e)
+	if !slices.Contains(authMethods(md), authMethodTLSClientAuth) || !slices.Contains(authMethods(md), authMethodSelfSignedTLSClientAuth) {
+		t.Errorf("mutual-TLS auth methods not advertised: %v", authMethods(md))
+	}
+	if md["tls_client_certificate_bound_access_tokens"] != true {
+		t.Error("tls_client_certificate_bound_access_tokens not advertised")
+	}
+	// building the metadata must not change what the server supports
+	md = s.h.metadata(s.e)
+	if !slices.Contains(authMethods(md), authMethodNone) {
+		t.Errorf("none no longer advertised: %v", authMethods(md))
+	}
+}
+
+func TestRegisterWithoutMTLS(t *testing.T) {
+	s := newTestServer(t)
+
+	for _, body := range []string{
+		`{"redirect_uris":["https://client.example/cb"],"token_endpoint_auth_method":"tls_client_auth","tls_client_auth_subject_dn":"CN=client"}`,
+		`{"redirect_uris":["https://client.example/cb"],"token_endpoint_auth_method":"self_signed_tls_client_auth","jwks_uri":"https://client.example/jwks"}`,
+		`{"redirect_uris":["https://client.example/cb"],"tls_client_certificate_bound_access_tokens":true}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := s.do(req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("register %s: status = %d, want %d", body, rec.Code, http.StatusBadRequest)
+			continue
+		}
+		var res map[string]string
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil || res["error"] != "invalid_client_metadata" {
+			t.Errorf("register %s: unexpected response %s", body, rec.Body)
+		}
+	}
+}
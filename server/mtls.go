@@ -0,0 +1,156 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/voice0726/oauth-playground/model"
+)
+
+const (
+	authMethodTLSClientAuth           = "tls_client_auth"
+	authMethodSelfSignedTLSClientAuth = "self_signed_tls_client_auth"
+)
+
+// MTLSConfig enables the mutual-TLS listener started by Server.StartTLS.
+type MTLSConfig struct {
+	// BaseURL is where the TLS listener is reachable, e.g. https://localhost:9443
+	BaseURL string
+	// ClientCAs are the roots client certificates must chain to for
+	// tls_client_auth
+	ClientCAs *x509.CertPool
+}
+
+// endpoints advertised under mtls_endpoint_aliases (RFC 8705 section 5)
+var mtlsEndpoints = []string{"token_endpoint", "revocation_endpoint", "introspection_endpoint", "pushed_authorization_request_endpoint", "device_authorization_endpoint", "userinfo_endpoint"}
+
+func isMTLSAuthMethod(method string) bool {
+	return method == authMethodTLSClientAuth || method == authMethodSelfSignedTLSClientAuth
+}
+
+// clientCertificate returns the certificate the client presented on the TLS
+// connection of the request, if any.
+func clientCertificate(c echo.Context) *x509.Certificate {
+	state := c.Request().TLS
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	return state.PeerCertificates[0]
+}
+
+// baseURL returns the base URL the request was sent to, which is the mTLS
+// alias rather than the issuer on the TLS listener.
+func (h *Handler) baseURL(c echo.Context) string {
+	if c.Request().TLS != nil && h.mtls != nil {
+		return h.mtls.BaseURL
+	}
+	return h.issuer
+}
+
+// certificateThumbprint returns the x5t#S256 value of cert.
+func certificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// verifyClientCertificate authenticates the client by the certificate of the
+// TLS connection (RFC 8705 section 2).
+func (h *Handler) verifyClientCertificate(c echo.Context, client *model.Client) error {
+	cert := clientCertificate(c)
+	if cert == nil {
+		return errInvalidClient
+	}
+
+	switch client.TokenEndpointAuthMethod {
+	case authMethodTLSClientAuth:
+		if h.mtls == nil || h.mtls.ClientCAs == nil {
+			return errInvalidClient
+		}
+		intermediates := x509.NewCertPool()
+		for _, ic := range c.Request().TLS.PeerCertificates[1:] {
+			intermediates.AddCert(ic)
+		}
+		_, err := cert.Verify(x509.VerifyOptions{
+			Roots:         h.mtls.ClientCAs,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		if err != nil {
+			return errInvalidClient
+		}
+		if client.TLSClientAuthSubjectDN == "" || cert.Subject.String() != client.TLSClientAuthSubjectDN {
+			return errInvalidClient
+		}
+		return nil
+
+	case authMethodSelfSignedTLSClientAuth:
+		keys, err := h.clientKeySet(client)
+		if err != nil {
+			if errors.Is(err, errClientKeysNotRegistered) {
+				return errInvalidClient
+			}
+			return err
+		}
+		// the certificate itself is registered, either as x5c or by its
+		// thumbprint
+		sum := sha256.Sum256(cert.Raw)
+		for _, key := range keys.Keys {
+			if len(key.Certificates) > 0 && bytes.Equal(key.Certificates[0].Raw, cert.Raw) {
+				return nil
+			}
+			if bytes.Equal(key.CertificateThumbprintSHA256, sum[:]) {
+				return nil
+			}
+		}
+		return errInvalidClient
+	}
+
+	return errInvalidClient
+}
+
+// selfSignedCertificate generates a server certificate for local testing.
+func selfSignedCertificate(hosts ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/voice0726/oauth-playground/dpop"
+	"github.com/voice0726/oauth-playground/model"
+	"go.step.sm/crypto/jose"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	scopeOpenID = "openid"
+	idTokenTTL  = 10 * time.Minute
+)
+
+var errUserNotFound = errors.New("user not found")
+
+// OpenID Connect Core section 5.4
+var scopeClaims = map[string][]string{
+	"profile": {"name", "family_name", "given_name", "middle_name", "nickname", "preferred_username", "profile", "picture", "website", "gender", "birthdate", "zoneinfo", "locale", "updated_at"},
+	"email":   {"email", "email_verified"},
+	"address": {"address"},
+	"phone":   {"phone_number", "phone_number_verified"},
+}
+
+type idTokenClaims struct {
+	jose.Claims
+	Nonce    string `json:"nonce,omitempty"`
+	AuthTime int64  `json:"auth_time,omitempty"`
+	AtHash   string `json:"at_hash,omitempty"`
+	CHash    string `json:"c_hash,omitempty"`
+}
+
+// signIDToken signs an ID token for subject. The access token and code are
+// those returned alongside it from the authorization endpoint, if any, and
+// are bound to it by their hashes.
+func (h *Handler) signIDToken(client *model.Client, subject, nonce string, authTime time.Time, accessToken, code string) (string, error) {
+	now := time.Now()
+	claims := idTokenClaims{
+		Claims: jose.Claims{
+			Issuer:   h.issuer,
+			Subject:  subject,
+			Audience: jose.Audience{client.Name},
+			Expiry:   jose.NewNumericDate(now.Add(idTokenTTL)),
+			IssuedAt: jose.NewNumericDate(now),
+			ID:       uuid.NewString(),
+		},
+		Nonce: nonce,
+	}
+	if !authTime.IsZero() {
+		claims.AuthTime = authTime.Unix()
+	}
+	if accessToken != "" {
+		claims.AtHash = tokenHash(accessToken)
+	}
+	if code != "" {
+		claims.CHash = tokenHash(code)
+	}
+	return h.keys.sign(claims, "JWT")
+}
+
+// tokenHash is the at_hash and c_hash value for ES256 ID tokens: the left
+// half of the SHA-256 hash of the value (OpenID Connect Core section 3.3.2.11).
+func tokenHash(v string) string {
+	sum := sha256.Sum256([]byte(v))
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2])
+}
+
+func (h *Handler) userClaims(subject string) (map[string]interface{}, error) {
+	user, err := h.userRepository.FindByID(subject)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errUserNotFound
+		}
+		return nil, err
+	}
+
+	claims := map[string]interface{}{"preferred_username": user.Username}
+	for name, v := range map[string]string{"name": user.Name, "given_name": user.GivenName, "family_name": user.FamilyName} {
+		if v != "" {
+			claims[name] = v
+		}
+	}
+	if user.Email != "" {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.EmailVerified
+	}
+	return claims, nil
+}
+
+func (h *Handler) HandleUserinfo(c echo.Context) error {
+	t, err := h.authenticateAccessToken(c)
+	if err != nil {
+		if !errors.Is(err, errMissingAccessToken) && !errors.Is(err, errInvalidAccessToken) && !errors.Is(err, dpop.ErrInvalidProof) {
+			h.logger.Error("failed to authenticate access token", zap.Error(err))
+		}
+		return accessTokenErrorResponse(c, err)
+	}
+
+	if !hasScope(t.Scope, scopeOpenID) {
+		c.Response().Header().Set("WWW-Authenticate", tokenType(t)+` error="insufficient_scope", scope="openid"`)
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "insufficient_scope"})
+	}
+
+	claims, err := h.userClaims(t.Subject)
+	if err != nil {
+		if errors.Is(err, errUserNotFound) {
+			return accessTokenErrorResponse(c, errInvalidAccessToken)
+		}
+		h.logger.Error("failed to get user claims", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+
+	res := filterClaims(claims, t.Scope)
+	res["sub"] = t.Subject
+	return c.JSON(http.StatusOK, res)
+}
+
+func filterClaims(claims map[string]interface{}, scope string) map[string]interface{} {
+	res := map[string]interface{}{}
+	for _, s := range strings.Fields(scope) {
+		for _, name := range scopeClaims[s] {
+			if v, ok := claims[name]; ok {
+				res[name] = v
+			}
+		}
+	}
+	return res
+}
+
+func hasScope(scope, s string) bool {
+	return slices.Contains(strings.Fields(scope), s)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/voice0726/oauth-playground/model"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	// RFC 9126 section 2.2
+	requestURIPrefix = "urn:ietf:params:oauth:request_uri:"
+	// the advertised expires_in; see HandleAuthorize for the login extension
+	pushedRequestTTL = time.Minute
+)
+
+var errInvalidRequestURI = errors.New("invalid request_uri")
+
+func (h *Handler) HandlePushedAuthorizationRequest(c echo.Context) error {
+	client, err := h.authenticateClientRequest(c)
+	if err != nil {
+		if errors.Is(err, errCredentialsInQuery) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": err.Error()})
+		}
+		if isClientAuthenticationError(err) {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		}
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+
+	form, err := c.FormParams()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "malformed request body"})
+	}
+	// RFC 9126 section 2.1
+	if form.Has("request_uri") {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "request_uri is not allowed"})
+	}
+	if form.Has("request") {
+		form, err = h.resolveRequestObject(client, form)
+		if err != nil {
+			h.logger.Info("invalid request object", zap.Error(err))
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": requestObjectErrorCode(err), "error_description": err.Error()})
+		}
+	}
+	params := authorizationParamsFromValues(form)
+	if params.ClientID != "" && params.ClientID != client.Name {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "client_id does not match the authenticated client"})
+	}
+
+	if err := validateAuthorizationRequest(client, params); err != nil {
+		var authErr *authorizationError
+		if errors.As(err, &authErr) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": authErr.code, "error_description": authErr.description})
+		}
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+
+	req, err := h.authRequestRepository.CreateRequest(model.AuthRequest{
+		ClientID:            client.ID,
+		RedirectURI:         params.RedirectURI,
+		ResponseType:        params.ResponseType,
+		ResponseMode:        params.ResponseMode,
+		State:               params.State,
+		Scope:               params.Scope,
+		CodeChallenge:       params.CodeChallenge,
+		CodeChallengeMethod: params.CodeChallengeMethod,
+		Nonce:               params.Nonce,
+		Prompt:              params.Prompt,
+		Pushed:              true,
+		ExpiresAt:           time.Now().Add(pushedRequestTTL),
+	})
+	if err != nil {
+		h.logger.Error("failed to save pushed request", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+
+	// expires_in is how long the client has to send the user to the
+	// authorization endpoint. Once the user gets there without a session,
+	// HandleAuthorize extends the request to authRequestTTL after the push,
+	// so that it survives the login it redirects to.
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"request_uri": requestURIPrefix + req.ID.String(),
+		"expires_in":  int64(pushedRequestTTL.Seconds()),
+	})
+}
+
+// findPushedRequest resolves a request_uri issued by the PAR endpoint to
+// the stored request, which must belong to the client and still be valid.
+func (h *Handler) findPushedRequest(requestURI string, client *model.Client) (*model.AuthRequest, error) {
+	ref, ok := strings.CutPrefix(requestURI, requestURIPrefix)
+	if !ok {
+		return nil, errInvalidRequestURI
+	}
+	id, err := uuid.Parse(ref)
+	if err != nil {
+		return nil, errInvalidRequestURI
+	}
+
+	req, err := h.authRequestRepository.FindRequestByID(id.String())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errInvalidRequestURI
+		}
+		return nil, err
+	}
+	if !req.Pushed || req.ClientID != client.ID || time.Now().After(req.ExpiresAt) {
+		return nil, errInvalidRequestURI
+	}
+
+	return req, nil
+}
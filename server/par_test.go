@@ -0,0 +1,117 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/voice0726/oauth-playground/model"
+)
+
+// pushRequest pushes an authorization request for client and returns its
+// request_uri.
+func (s *testServer) pushRequest(t *testing.T, client *model.Client) string {
+	t.Helper()
+	form := url.Values{
+		"redirect_uri":  {client.RedirectURIs[0]},
+		"response_type": {"code"},
+		"scope":         {"profile"},
+		"state":         {"state"},
+	}
+	rec := s.postForm("/par", form, client.Name, client.Secret)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("par status = %d: %s", rec.Code, rec.Body)
+	}
+	var res struct {
+		RequestURI string `json:"request_uri"`
+		ExpiresIn  int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.ExpiresIn != int64(pushedRequestTTL.Seconds()) {
+		t.Errorf("expires_in = %d, want %d", res.ExpiresIn, int64(pushedRequestTTL.Seconds()))
+	}
+	return res.RequestURI
+}
+
+func requestURIID(t *testing.T, requestURI string) uuid.UUID {
+	t.Helper()
+	id, err := uuid.Parse(strings.TrimPrefix(requestURI, requestURIPrefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestPushedAuthorizationRequest(t *testing.T) {
+	s := newTestServer(t)
+	client := s.createClient(t, model.Client{})
+	otherClient := s.createClient(t, model.Client{})
+	_, session := s.login(t)
+
+	authorize := func(client *model.Client, requestURI string, cookies ...*http.Cookie) int {
+		q := url.Values{"client_id": {client.Name}, "request_uri": {requestURI}}
+		return s.get("/authorize?"+q.Encode(), cookies...).Code
+	}
+
+	t.Run("single use", func(t *testing.T) {
+		requestURI := s.pushRequest(t, client)
+		if code := authorize(client, requestURI, session); code != http.StatusOK {
+			t.Fatalf("first use: status = %d", code)
+		}
+		if code := authorize(client, requestURI, session); code != http.StatusBadRequest {
+			t.Errorf("second use: status = %d, want %d", code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		requestURI := s.pushRequest(t, client)
+		if err := s.h.authRequestRepository.UpdateExpiry(requestURIID(t, requestURI), time.Now().Add(-time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		if code := authorize(client, requestURI, session); code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("another client", func(t *testing.T) {
+		requestURI := s.pushRequest(t, client)
+		if code := authorize(otherClient, requestURI, session); code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", code, http.StatusBadRequest)
+		}
+		// a failed attempt does not use it up
+		if code := authorize(client, requestURI, session); code != http.StatusOK {
+			t.Errorf("status = %d, want %d", code, http.StatusOK)
+		}
+	})
+
+	t.Run("outlives the login", func(t *testing.T) {
+		requestURI := s.pushRequest(t, client)
+		if code := authorize(client, requestURI); code != http.StatusSeeOther {
+			t.Fatalf("without a session: status = %d, want a redirect to the login page", code)
+		}
+		req, err := s.h.authRequestRepository.FindRequestByID(requestURIID(t, requestURI).String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := req.CreatedAt.Add(authRequestTTL); !req.ExpiresAt.Equal(want) {
+			t.Errorf("expires at %s, want %s", req.ExpiresAt, want)
+		}
+		if code := authorize(client, requestURI, session); code != http.StatusOK {
+			t.Errorf("after login: status = %d", code)
+		}
+	})
+
+	t.Run("request_uri cannot be pushed", func(t *testing.T) {
+		form := url.Values{"request_uri": {requestURIPrefix + uuid.NewString()}}
+		rec := s.postForm("/par", form, client.Name, client.Secret)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+		}
+	})
+}
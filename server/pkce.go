@@ -0,0 +1,46 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+	"slices"
+)
+
+const (
+	codeChallengeMethodPlain = "plain"
+	codeChallengeMethodS256  = "S256"
+)
+
+// RFC 7636 section 4.1: 43 to 128 characters from the unreserved set.
+var pkceValuePattern = regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+
+var codeChallengeMethodsSupported = []string{codeChallengeMethodS256, codeChallengeMethodPlain}
+
+func isSupportedCodeChallengeMethod(method string) bool {
+	return slices.Contains(codeChallengeMethodsSupported, method)
+}
+
+func isValidCodeChallenge(challenge string) bool {
+	return pkceValuePattern.MatchString(challenge)
+}
+
+func verifyCodeVerifier(verifier, challenge, method string) bool {
+	if !pkceValuePattern.MatchString(verifier) {
+		return false
+	}
+
+	var computed string
+	switch method {
+	case codeChallengeMethodS256:
+		sum := sha256.Sum256([]byte(verifier))
+		computed = base64.RawURLEncoding.EncodeToString(sum[:])
+	case codeChallengeMethodPlain:
+		computed = verifier
+	default:
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyCodeVerifier(t *testing.T) {
+	// RFC 7636 appendix B
+	const (
+		verifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+		challenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	)
+
+	tests := []struct {
+		name      string
+		verifier  string
+		challenge string
+		method    string
+		want      bool
+	}{
+		{name: "S256", verifier: verifier, challenge: challenge, method: codeChallengeMethodS256, want: true},
+		{name: "plain", verifier: verifier, challenge: verifier, method: codeChallengeMethodPlain, want: true},
+		{name: "S256 with wrong verifier", verifier: strings.Repeat("a", 43), challenge: challenge, method: codeChallengeMethodS256},
+		{name: "S256 challenge used as plain", verifier: verifier, challenge: challenge, method: codeChallengeMethodPlain},
+		{name: "plain challenge used as S256", verifier: verifier, challenge: verifier, method: codeChallengeMethodS256},
+		{name: "unsupported method", verifier: verifier, challenge: verifier, method: "S512"},
+		{name: "empty method", verifier: verifier, challenge: verifier, method: ""},
+		{name: "too short", verifier: strings.Repeat("a", 42), challenge: strings.Repeat("a", 42), method: codeChallengeMethodPlain},
+		{name: "too long", verifier: strings.Repeat("a", 129), challenge: strings.Repeat("a", 129), method: codeChallengeMethodPlain},
+		{name: "shortest", verifier: strings.Repeat("a", 43), challenge: strings.Repeat("a", 43), method: codeChallengeMethodPlain, want: true},
+		{name: "longest", verifier: strings.Repeat("a", 128), challenge: strings.Repeat("a", 128), method: codeChallengeMethodPlain, want: true},
+		{name: "reserved character", verifier: strings.Repeat("a", 42) + "+", challenge: strings.Repeat("a", 42) + "+", method: codeChallengeMethodPlain},
+		{name: "empty", method: codeChallengeMethodPlain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := verifyCodeVerifier(tt.verifier, tt.challenge, tt.method); got != tt.want {
+				t.Errorf("verifyCodeVerifier() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
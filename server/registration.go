@@ -0,0 +1,458 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/voice0726/oauth-playground/model"
+	"go.step.sm/crypto/jose"
+	"go.step.sm/crypto/randutil"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+type clientMetadata struct {
+	RedirectURIs            []string            `json:"redirect_uris,omitempty"`
+	TokenEndpointAuthMethod string              `json:"token_endpoint_auth_method,omitempty"`
+	GrantTypes              []string            `json:"grant_types,omitempty"`
+	ResponseTypes           []string            `json:"response_types,omitempty"`
+	ClientName              string              `json:"client_name,omitempty"`
+	Scope                   string              `json:"scope,omitempty"`
+	JWKSURI                 string              `json:"jwks_uri,omitempty"`
+	JWKS                    *jose.JSONWebKeySet `json:"jwks,omitempty"`
+	RequestURIs             []string            `json:"request_uris,omitempty"`
+	// RFC 9126 section 6
+	RequirePushedAuthorizationRequests bool `json:"require_pushed_authorization_requests,omitempty"`
+	// RFC 8705 section 2.1.2 and 3.4
+	TLSClientAuthSubjectDN                string `json:"tls_client_auth_subject_dn,omitempty"`
+	TLSClientCertificateBoundAccessTokens bool   `json:"tls_client_certificate_bound_access_tokens,omitempty"`
+	// not part of RFC 7591; selects the format of the access tokens issued to
+	// the client ("jwt" or "opaque")
+	AccessTokenFormat string `json:"access_token_format,omitempty"`
+	// the issuers a client trusts for the jwt-bearer grant are part of its
+	// ClientPolicy; only read so that registration can reject them
+	JWTBearerIssuers json.RawMessage `json:"jwt_bearer_issuers,omitempty"`
+	// likewise part of the ClientPolicy
+	TokenExchangePolicy json.RawMessage `json:"token_exchange_policy,omitempty"`
+}
+
+type clientInformation struct {
+	ClientID                string `json:"client_id"`
+	ClientSecret            string `json:"client_secret,omitempty"`
+	ClientIDIssuedAt        int64  `json:"client_id_issued_at"`
+	ClientSecretExpiresAt   int64  `json:"client_secret_expires_at"`
+	RegistrationAccessToken string `json:"registration_access_token,omitempty"`
+	RegistrationClientURI   string `json:"registration_client_uri"`
+	clientMetadata
+}
+
+type registrationError struct {
+	code        string
+	description string
+}
+
+func (e *registrationError) Error() string {
+	return e.description
+}
+
+func (h *Handler) HandleRegister(c echo.Context) error {
+	var md clientMetadata
+	if err := c.Bind(&md); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_client_metadata", "error_description": "malformed request body"})
+	}
+
+	client := model.Client{}
+	if err := h.applyClientMetadata(&client, &md); err != nil {
+		return registrationErrorResponse(c, err)
+	}
+
+	clientID, err := randutil.Alphanumeric(24)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+	// only the secret-based methods have a use for a client secret
+	var clientSecret string
+	if usesClientSecret(client.TokenEndpointAuthMethod) {
+		clientSecret, err = randutil.Alphanumeric(48)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "internal server error")
+		}
+	}
+	registrationToken, err := randutil.Alphanumeric(48)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+	client.Name = clientID
+	client.Secret = clientSecret
+	client.RegistrationAccessTokenHash = hashToken(registrationToken)
+
+	created, err := h.clientRepository.Create(client)
+	if err != nil {
+		h.logger.Error("failed to register client", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+
+	info := h.clientInformation(created)
+	info.RegistrationAccessToken = registrationToken
+	return c.JSON(http.StatusCreated, info)
+}
+
+func (h *Handler) HandleGetRegistration(c echo.Context) error {
+	client, err := h.authenticateRegistration(c)
+	if err != nil {
+		return registrationAuthErrorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, h.clientInformation(client))
+}
+
+func (h *Handler) HandleUpdateRegistration(c echo.Context) error {
+	client, err := h.authenticateRegistration(c)
+	if err != nil {
+		return registrationAuthErrorResponse(c, err)
+	}
+
+	var body struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		clientMetadata
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_client_metadata", "error_description": "malformed request body"})
+	}
+
+	// RFC 7592 section 2.2
+	if body.ClientID != client.Name {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_client_metadata", "error_description": "client_id does not match"})
+	}
+	if body.ClientSecret != "" && subtle.ConstantTimeCompare([]byte(body.ClientSecret), []byte(client.Secret)) != 1 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_client_metadata", "error_description": "client_secret does not match"})
+	}
+
+	if err := h.applyClientMetadata(client, &body.clientMetadata); err != nil {
+		return registrationErrorResponse(c, err)
+	}
+	// the secret follows the auth method, which the update may have changed
+	switch {
+	case !usesClientSecret(client.TokenEndpointAuthMethod):
+		client.Secret = ""
+	case client.Secret == "":
+		client.Secret, err = randutil.Alphanumeric(48)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "internal server error")
+		}
+	}
+
+	updated, err := h.clientRepository.Update(*client)
+	if err != nil {
+		h.logger.Error("failed to update client", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+
+	return c.JSON(http.StatusOK, h.clientInformation(updated))
+}
+
+func (h *Handler) HandleDeleteRegistration(c echo.Context) error {
+	client, err := h.authenticateRegistration(c)
+	if err != nil {
+		return registrationAuthErrorResponse(c, err)
+	}
+
+	if err := h.tokenRepository.RevokeByClient(client.ID); err != nil {
+		h.logger.Error("failed to revoke access tokens", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+	if err := h.refreshTokenRepository.RevokeByClient(client.ID); err != nil {
+		h.logger.Error("failed to revoke refresh tokens", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+	if err := h.clientRepository.Delete(client.ID); err != nil {
+		h.logger.Error("failed to delete client", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) authenticateRegistration(c echo.Context) (*model.Client, error) {
+	token, ok := strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, errInvalidClient
+	}
+
+	client, err := h.clientRepository.FindClientByName(c.Param("client_id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errInvalidClient
+		}
+		return nil, err
+	}
+
+	// clients that were not dynamically registered cannot be managed here
+	if client.RegistrationAccessTokenHash == "" ||
+		subtle.ConstantTimeCompare([]byte(client.RegistrationAccessTokenHash), []byte(hashToken(token))) != 1 {
+		return nil, errInvalidClient
+	}
+
+	return client, nil
+}
+
+func (h *Handler) applyClientMetadata(client *model.Client, md *clientMetadata) error {
+	if len(md.GrantTypes) == 0 {
+		md.GrantTypes = []string{"authorization_code"}
+	}
+	if len(md.ResponseTypes) == 0 && slices.Contains(md.GrantTypes, "authorization_code") {
+		md.ResponseTypes = []string{"code"}
+	}
+	if md.TokenEndpointAuthMethod == "" {
+		md.TokenEndpointAuthMethod = authMethodClientSecretBasic
+	}
+
+	supportedGrantTypes := h.grantTypesSupported()
+	for _, gt := range md.GrantTypes {
+		if !slices.Contains(supportedGrantTypes, gt) {
+			return &registrationError{"invalid_client_metadata", "unsupported grant type: " + gt}
+		}
+	}
+	for i, rt := range md.ResponseTypes {
+		rt = normalizeResponseType(rt)
+		if !slices.Contains(responseTypesSupported, rt) {
+			return &registrationError{"invalid_client_metadata", "unsupported response type: " + rt}
+		}
+		md.ResponseTypes[i] = rt
+	}
+	// RFC 7591 section 2.1
+	usesCode := slices.ContainsFunc(md.ResponseTypes, func(rt string) bool { return responseTypeHas(rt, "code") })
+	usesImplicit := slices.ContainsFunc(md.ResponseTypes, func(rt string) bool { return rt != "code" })
+	if slices.Contains(md.GrantTypes, "authorization_code") != usesCode ||
+		slices.Contains(md.GrantTypes, grantTypeImplicit) != usesImplicit {
+		return &registrationError{"invalid_client_metadata", "grant_types and response_types are inconsistent"}
+	}
+	if !slices.Contains(h.tokenEndpointAuthMethods(), md.TokenEndpointAuthMethod) {
+		return &registrationError{"invalid_client_metadata", "unsupported token endpoint auth method: " + md.TokenEndpointAuthMethod}
+	}
+
+	if len(md.ResponseTypes) > 0 && len(md.RedirectURIs) == 0 {
+		return &registrationError{"invalid_redirect_uri", "redirect_uris required"}
+	}
+	for _, u := range md.RedirectURIs {
+		if err := validateRedirectURI(u); err != nil {
+			return err
+		}
+	}
+
+	for _, sc := range strings.Fields(md.Scope) {
+		if _, ok := scopeCatalog[sc]; !ok {
+			return &registrationError{"invalid_client_metadata", "unknown scope: " + sc}
+		}
+	}
+
+	if md.JWKS != nil && md.JWKSURI != "" {
+		return &registrationError{"invalid_client_metadata", "jwks and jwks_uri must not both be present"}
+	}
+	jwks, err := marshalPublicKeySet(md.JWKS)
+	if err != nil {
+		return err
+	}
+	if md.JWKSURI != "" {
+		if err := validateClientURI(md.JWKSURI); err != nil {
+			return err
+		}
+	}
+	for _, u := range md.RequestURIs {
+		if err := validateClientURI(u); err != nil {
+			return err
+		}
+	}
+
+	// without the mutual-TLS listener no certificate ever reaches the server
+	if md.TLSClientCertificateBoundAccessTokens && h.mtls == nil {
+		return &registrationError{"invalid_client_metadata", "certificate-bound access tokens are not supported"}
+	}
+
+	switch md.TokenEndpointAuthMethod {
+	case authMethodTLSClientAuth:
+		if md.TLSClientAuthSubjectDN == "" {
+			return &registrationError{"invalid_client_metadata", "tls_client_auth_subject_dn required for tls_client_auth"}
+		}
+	case authMethodSelfSignedTLSClientAuth, authMethodPrivateKeyJWT:
+		if md.JWKS == nil && md.JWKSURI == "" {
+			return &registrationError{"invalid_client_metadata", "jwks or jwks_uri required for " + md.TokenEndpointAuthMethod}
+		}
+	case authMethodNone:
+		// public clients cannot act on their own behalf
+		if slices.Contains(md.GrantTypes, "client_credentials") {
+			return &registrationError{"invalid_client_metadata", "client_credentials requires client authentication"}
+		}
+	}
+
+	// trusting an issuer lets the client get tokens for any user that issuer
+	// names, so it is not something a client can grant itself
+	if md.JWTBearerIssuers != nil {
+		return &registrationError{"invalid_client_metadata", "jwt_bearer_issuers is configured by the operator"}
+	}
+	if md.TokenExchangePolicy != nil {
+		return &registrationError{"invalid_client_metadata", "token_exchange_policy is configured by the operator"}
+	}
+
+	var accessTokenFormat string
+	switch md.AccessTokenFormat {
+	case "", "opaque":
+		accessTokenFormat = model.AccessTokenFormatOpaque
+	case "jwt":
+		accessTokenFormat = model.AccessTokenFormatJWT
+	default:
+		return &registrationError{"invalid_client_metadata", "unsupported access token format: " + md.AccessTokenFormat}
+	}
+
+	client.ClientName = md.ClientName
+	client.RedirectURIs = md.RedirectURIs
+	client.GrantTypes = md.GrantTypes
+	client.ResponseTypes = md.ResponseTypes
+	client.TokenEndpointAuthMethod = md.TokenEndpointAuthMethod
+	client.Scopes = strings.Fields(md.Scope)
+	client.AccessTokenFormat = accessTokenFormat
+	client.RequirePushedAuthorizationRequests = md.RequirePushedAuthorizationRequests
+	client.JWKS = jwks
+	client.JWKSURI = md.JWKSURI
+	client.RequestURIs = md.RequestURIs
+	client.TLSClientAuthSubjectDN = md.TLSClientAuthSubjectDN
+	client.TLSClientCertificateBoundAccessTokens = md.TLSClientCertificateBoundAccessTokens
+	return nil
+}
+
+// marshalPublicKeySet stores a key set registered by value, which must only
+// hold public keys.
+func marshalPublicKeySet(keys *jose.JSONWebKeySet) (string, error) {
+	if keys == nil {
+		return "", nil
+	}
+	for _, key := range keys.Keys {
+		if !key.Valid() || !key.IsPublic() {
+			return "", &registrationError{"invalid_client_metadata", "jwks must only contain valid public keys"}
+		}
+	}
+	b, err := json.Marshal(keys)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// validateRedirectURI accepts https URIs, http on loopback hosts and the
+// private-use schemes native apps register (RFC 8252 section 7).
+func validateRedirectURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() {
+		return &registrationError{"invalid_redirect_uri", "redirect uri must be absolute: " + raw}
+	}
+	if u.Fragment != "" {
+		return &registrationError{"invalid_redirect_uri", "redirect uri must not contain a fragment: " + raw}
+	}
+
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		switch u.Hostname() {
+		case "localhost", "127.0.0.1", "::1":
+			return nil
+		}
+		return &registrationError{"invalid_redirect_uri", "http redirect uris are only allowed for loopback hosts: " + raw}
+	default:
+		if strings.Contains(u.Scheme, ".") {
+			return nil
+		}
+		return &registrationError{"invalid_redirect_uri", "unsupported redirect uri scheme: " + raw}
+	}
+}
+
+// validateClientURI accepts URIs the server fetches documents from on behalf
+// of the client: https, or http on loopback hosts for local testing.
+func validateClientURI(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() {
+		return &registrationError{"invalid_client_metadata", "uri must be absolute: " + raw}
+	}
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		switch u.Hostname() {
+		case "localhost", "127.0.0.1", "::1":
+			return nil
+		}
+	}
+	return &registrationError{"invalid_client_metadata", "uri must use https: " + raw}
+}
+
+func (h *Handler) clientInformation(client *model.Client) *clientInformation {
+	accessTokenFormat := ""
+	if client.AccessTokenFormat == model.AccessTokenFormatJWT {
+		accessTokenFormat = "jwt"
+	}
+
+	return &clientInformation{
+		ClientID:              client.Name,
+		ClientSecret:          client.Secret,
+		ClientIDIssuedAt:      client.CreatedAt.Unix(),
+		ClientSecretExpiresAt: 0,
+		RegistrationClientURI: h.issuer + "/register/" + url.PathEscape(client.Name),
+		clientMetadata: clientMetadata{
+			RedirectURIs:                          client.RedirectURIs,
+			TokenEndpointAuthMethod:               client.TokenEndpointAuthMethod,
+			GrantTypes:                            client.GrantTypes,
+			ResponseTypes:                         client.ResponseTypes,
+			ClientName:                            client.ClientName,
+			Scope:                                 strings.Join(client.Scopes, " "),
+			AccessTokenFormat:                     accessTokenFormat,
+			RequirePushedAuthorizationRequests:    client.RequirePushedAuthorizationRequests,
+			JWKSURI:                               client.JWKSURI,
+			JWKS:                                  unmarshalKeySet(client.JWKS),
+			RequestURIs:                           client.RequestURIs,
+			TLSClientAuthSubjectDN:                client.TLSClientAuthSubjectDN,
+			TLSClientCertificateBoundAccessTokens: client.TLSClientCertificateBoundAccessTokens,
+		},
+	}
+}
+
+// unmarshalKeySet reads a key set stored by marshalPublicKeySet, so it is
+// known to be valid.
+func unmarshalKeySet(s string) *jose.JSONWebKeySet {
+	if s == "" {
+		return nil
+	}
+	keys := &jose.JSONWebKeySet{}
+	_ = json.Unmarshal([]byte(s), keys)
+	return keys
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func registrationErrorResponse(c echo.Context, err error) error {
+	var regErr *registrationError
+	if errors.As(err, &regErr) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": regErr.code, "error_description": regErr.description})
+	}
+	return c.JSON(http.StatusInternalServerError, "internal server error")
+}
+
+// registrationAuthErrorResponse answers with 401 for unknown clients as well,
+// as RFC 7592 section 2 requires, so that client IDs cannot be probed.
+func registrationAuthErrorResponse(c echo.Context, err error) error {
+	if errors.Is(err, errInvalidClient) {
+		c.Response().Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+	}
+	return c.JSON(http.StatusInternalServerError, "internal server error")
+}
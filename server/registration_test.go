@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.step.sm/crypto/jose"
+)
+
+func (s *testServer) registrationRequest(method, path, body, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return s.do(req)
+}
+
+func TestRegisterValidatesMetadata(t *testing.T) {
+	s := newTestServer(t)
+	key, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicJWKS, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{key.Public()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateJWKS, err := json.Marshal(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{*key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{name: "minimal", body: `{"redirect_uris":["https://client.example/cb"]}`},
+		{name: "loopback http", body: `{"redirect_uris":["http://127.0.0.1:8080/cb"]}`},
+		{name: "private-use scheme", body: `{"redirect_uris":["com.example.app:/cb"],"token_endpoint_auth_method":"none"}`},
+		{name: "implicit", body: `{"redirect_uris":["https://client.example/cb"],"grant_types":["implicit"],"response_types":["id_token token"]}`},
+		{name: "client credentials only", body: `{"grant_types":["client_credentials"]}`},
+		{name: "private_key_jwt", body: `{"redirect_uris":["https://client.example/cb"],"token_endpoint_auth_method":"private_key_jwt","jwks":` + string(publicJWKS) + `}`},
+
+		{name: "no redirect uri", body: `{}`, wantErr: "invalid_redirect_uri"},
+		{name: "relative redirect uri", body: `{"redirect_uris":["/cb"]}`, wantErr: "invalid_redirect_uri"},
+		{name: "redirect uri with fragment", body: `{"redirect_uris":["https://client.example/cb#frag"]}`, wantErr: "invalid_redirect_uri"},
+		{name: "http redirect uri", body: `{"redirect_uris":["http://client.example/cb"]}`, wantErr: "invalid_redirect_uri"},
+		{name: "javascript redirect uri", body: `{"redirect_uris":["javascript:alert(1)"]}`, wantErr: "invalid_redirect_uri"},
+		{name: "unknown grant type", body: `{"redirect_uris":["https://client.example/cb"],"grant_types":["password"]}`, wantErr: "invalid_client_metadata"},
+		{name: "unknown response type", body: `{"redirect_uris":["https://client.example/cb"],"response_types":["code foo"]}`, wantErr: "invalid_client_metadata"},
+		{name: "inconsistent grant and response types", body: `{"redirect_uris":["https://client.example/cb"],"grant_types":["authorization_code"],"response_types":["token"]}`, wantErr: "invalid_client_metadata"},
+		{name: "unknown auth method", body: `{"redirect_uris":["https://client.example/cb"],"token_endpoint_auth_method":"client_secret_magic"}`, wantErr: "invalid_client_metadata"},
+		{name: "unknown scope", body: `{"redirect_uris":["https://client.example/cb"],"scope":"openid admin"}`, wantErr: "invalid_client_metadata"},
+		{name: "public client with client credentials", body: `{"grant_types":["client_credentials"],"token_endpoint_auth_method":"none"}`, wantErr: "invalid_client_metadata"},
+		{name: "private_key_jwt without keys", body: `{"redirect_uris":["https://client.example/cb"],"token_endpoint_auth_method":"private_key_jwt"}`, wantErr: "invalid_client_metadata"},
+		{name: "private key in jwks", body: `{"redirect_uris":["https://client.example/cb"],"token_endpoint_auth_method":"private_key_jwt","jwks":` + string(privateJWKS) + `}`, wantErr: "invalid_client_metadata"},
+		{name: "jwks and jwks_uri", body: `{"redirect_uris":["https://client.example/cb"],"jwks":` + string(publicJWKS) + `,"jwks_uri":"https://client.example/jwks"}`, wantErr: "invalid_client_metadata"},
+		{name: "http jwks_uri", body: `{"redirect_uris":["https://client.example/cb"],"jwks_uri":"http://client.example/jwks"}`, wantErr: "invalid_client_metadata"},
+		{name: "unknown access token format", body: `{"redirect_uris":["https://client.example/cb"],"access_token_format":"saml"}`, wantErr: "invalid_client_metadata"},
+		{name: "jwt-bearer issuers", body: `{"redirect_uris":["https://client.example/cb"],"jwt_bearer_issuers":[{"issuer":"https://idp.example"}]}`, wantErr: "invalid_client_metadata"},
+		{name: "token exchange policy", body: `{"redirect_uris":["https://client.example/cb"],"token_exchange_policy":{"allow_impersonation":true}}`, wantErr: "invalid_client_metadata"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := s.registrationRequest(http.MethodPost, "/register", tt.body, "")
+			if tt.wantErr == "" {
+				if rec.Code != http.StatusCreated {
+					t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+				}
+				return
+			}
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+			}
+			if got := tokenError(t, rec); got != tt.wantErr {
+				t.Errorf("error = %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegistrationManagement(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := s.registrationRequest(http.MethodPost, "/register", `{"redirect_uris":["https://client.example/cb"]}`, "")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("register status = %d: %s", rec.Code, rec.Body)
+	}
+	var info clientInformation
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.ClientSecret == "" || info.RegistrationAccessToken == "" {
+		t.Fatalf("missing credentials: %s", rec.Body)
+	}
+	path := "/register/" + info.ClientID
+
+	if rec := s.registrationRequest(http.MethodGet, path, "", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("get with a wrong token: status = %d", rec.Code)
+	}
+	if rec := s.registrationRequest(http.MethodGet, "/register/unknown", "", info.RegistrationAccessToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("get of another client: status = %d", rec.Code)
+	}
+	if rec := s.registrationRequest(http.MethodGet, path, "", info.RegistrationAccessToken); rec.Code != http.StatusOK {
+		t.Errorf("get: status = %d: %s", rec.Code, rec.Body)
+	}
+
+	update := func(body string) *httptest.ResponseRecorder {
+		return s.registrationRequest(http.MethodPut, path, body, info.RegistrationAccessToken)
+	}
+	if rec := update(`{"client_id":"other","redirect_uris":["https://client.example/cb"]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("update with another client_id: status = %d", rec.Code)
+	}
+	if rec := update(`{"client_id":"` + info.ClientID + `","client_secret":"wrong","redirect_uris":["https://client.example/cb"]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("update with a wrong client_secret: status = %d", rec.Code)
+	}
+	if rec := update(`{"client_id":"` + info.ClientID + `","redirect_uris":["http://client.example/cb"]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("update with an invalid redirect uri: status = %d", rec.Code)
+	}
+
+	// a public client has no use for the secret
+	rec = update(`{"client_id":"` + info.ClientID + `","client_secret":"` + info.ClientSecret + `","redirect_uris":["https://client.example/cb"],"token_endpoint_auth_method":"none"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update status = %d: %s", rec.Code, rec.Body)
+	}
+	var updated clientInformation
+	if err := json.Unmarshal(rec.Body.Bytes(), &updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.ClientSecret != "" {
+		t.Error("client secret kept for a public client")
+	}
+
+	if rec := s.registrationRequest(http.MethodDelete, path, "", info.RegistrationAccessToken); rec.Code != http.StatusNoContent {
+		t.Errorf("delete: status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := s.registrationRequest(http.MethodGet, path, "", info.RegistrationAccessToken); rec.Code != http.StatusUnauthorized {
+		t.Errorf("get after delete: status = %d", rec.Code)
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/voice0726/oauth-playground/model"
+	"go.step.sm/crypto/jose"
+)
+
+var (
+	errInvalidRequestObject     = errors.New("invalid request object")
+	errRequestURINotRegistered  = errors.New("request_uri is not registered for the client")
+	errRequestAndRequestURISent = errors.New("request and request_uri must not both be present")
+)
+
+// resolveRequestObject returns the authorization parameters of a request
+// sent with a request object (RFC 9101), either by value in request or by
+// reference in request_uri. Values inside the object take precedence over
+// the ones in query.
+func (h *Handler) resolveRequestObject(client *model.Client, query url.Values) (url.Values, error) {
+	request := query.Get("request")
+	requestURI := query.Get("request_uri")
+	if request != "" && requestURI != "" {
+		return nil, errRequestAndRequestURISent
+	}
+
+	if requestURI != "" {
+		if !isRequestURIRegistered(client, requestURI) {
+			return nil, errRequestURINotRegistered
+		}
+		b, err := h.fetch(requestURI, "application/oauth-authz-req+jwt")
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errInvalidRequestObject, err)
+		}
+		request = string(b)
+	}
+
+	var claims jose.Claims
+	var params map[string]interface{}
+	if err := h.verifyClientJWT(client, request, &claims, &params); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidRequestObject, err)
+	}
+
+	// RFC 9101 section 4 and 6.3
+	expected := jose.Expected{
+		Issuer:   client.Name,
+		Audience: jose.Audience{h.issuer},
+		Time:     time.Now(),
+	}
+	if err := claims.ValidateWithLeeway(expected, time.Minute); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidRequestObject, err)
+	}
+	if id, ok := params["client_id"]; ok && id != client.Name {
+		return nil, fmt.Errorf("%w: client_id does not match", errInvalidRequestObject)
+	}
+
+	values := url.Values{}
+	for k, v := range query {
+		values[k] = v
+	}
+	values.Del("request")
+	values.Del("request_uri")
+	for k, v := range params {
+		switch k {
+		case "iss", "aud", "exp", "iat", "nbf", "jti":
+			continue
+		}
+		switch v := v.(type) {
+		case string:
+			values.Set(k, v)
+		default:
+			// non-string members such as max_age or claims keep their JSON form
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			values.Set(k, string(b))
+		}
+	}
+	return values, nil
+}
+
+// isRequestURIRegistered only lets the server fetch request objects from
+// locations the client registered in advance, so that request_uri cannot be
+// used to make it request arbitrary URLs. The fragment is ignored, as OpenID
+// Connect Core section 6.2 uses it to tell versions of a document apart.
+func isRequestURIRegistered(client *model.Client, requestURI string) bool {
+	uri, _, _ := strings.Cut(requestURI, "#")
+	for _, registered := range client.RequestURIs {
+		if r, _, _ := strings.Cut(registered, "#"); r == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// requestObjectErrorCode maps errors of resolveRequestObject to the error
+// codes of RFC 9101 section 6.3.
+func requestObjectErrorCode(err error) string {
+	switch {
+	case errors.Is(err, errRequestURINotRegistered):
+		return "invalid_request_uri"
+	case errors.Is(err, errInvalidRequestObject):
+		return "invalid_request_object"
+	default:
+		return "invalid_request"
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/voice0726/oauth-playground/model"
+	"go.step.sm/crypto/jose"
+)
+
+const (
+	responseModeQuery    = "query"
+	responseModeFragment = "fragment"
+	responseModeFormPost = "form_post"
+
+	// JWT Secured Authorization Response Mode (JARM) section 2.3
+	responseModeJWT         = "jwt"
+	responseModeQueryJWT    = "query.jwt"
+	responseModeFragmentJWT = "fragment.jwt"
+	responseModeFormPostJWT = "form_post.jwt"
+
+	// JARM section 2.1 recommends 10 minutes at most
+	authResponseTTL = 10 * time.Minute
+
+	// implicit is not a token endpoint grant, but clients register it for the
+	// response types that return tokens from the authorization endpoint
+	grantTypeImplicit = "implicit"
+)
+
+// normalizeResponseType orders the values of a response type so that, for
+// example, "id_token code" and "code id_token" compare equal (OAuth 2.0
+// Multiple Response Type Encoding Practices, section 5).
+func normalizeResponseType(responseType string) string {
+	values := strings.Fields(responseType)
+	slices.Sort(values)
+	return strings.Join(values, " ")
+}
+
+func responseTypeHas(responseType, value string) bool {
+	return slices.Contains(strings.Fields(responseType), value)
+}
+
+// isResponseTypeAllowed only allows the authorization code flow to clients
+// that did not register their response types; implicit and hybrid flows have
+// to be opted into.
+func isResponseTypeAllowed(client *model.Client, responseType string) bool {
+	if len(client.ResponseTypes) == 0 {
+		return responseType == "code"
+	}
+	return slices.ContainsFunc(client.ResponseTypes, func(rt string) bool {
+		return normalizeResponseType(rt) == responseType
+	})
+}
+
+// defaultResponseMode returns the mode used when the request names none:
+// fragment whenever tokens are returned and query otherwise.
+func defaultResponseMode(responseType string) string {
+	if responseTypeHas(responseType, "token") || responseTypeHas(responseType, "id_token") {
+		return responseModeFragment
+	}
+	return responseModeQuery
+}
+
+// responseModeFor returns the mode to answer the request in. The jwt mode
+// resolves to the JWT variant of the default mode (JARM section 2.3.4), and
+// the default is also used when the requested mode is not usable, as for
+// errors about the response mode itself.
+func responseModeFor(p *authorizationParams) string {
+	responseType := normalizeResponseType(p.ResponseType)
+	switch {
+	case p.ResponseMode == responseModeJWT:
+		return defaultResponseMode(responseType) + ".jwt"
+	case slices.Contains(responseModesSupported, p.ResponseMode):
+		return p.ResponseMode
+	}
+	return defaultResponseMode(responseType)
+}
+
+// sendAuthorizationResponse returns params to the client's redirect URI in
+// the given response mode. Every response names its issuer, so that a client
+// talking to several authorization servers can tell which one answered
+// (RFC 9207). The JWT modes wrap the parameters in a signed response JWT,
+// whose iss claim does the same.
+func (h *Handler) sendAuthorizationResponse(c echo.Context, client *model.Client, redirectURI, responseMode string, params url.Values) error {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+
+	params.Set("iss", h.issuer)
+
+	if mode, ok := strings.CutSuffix(responseMode, ".jwt"); ok {
+		response, err := h.signAuthorizationResponse(client, params)
+		if err != nil {
+			return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+		}
+		params = url.Values{"response": {response}}
+		responseMode = mode
+	}
+
+	switch responseMode {
+	case responseModeFragment:
+		u.Fragment = ""
+		return c.Redirect(http.StatusSeeOther, u.String()+"#"+params.Encode())
+	case responseModeFormPost:
+		c.Response().Header().Set("Cache-Control", "no-store")
+		return c.Render(http.StatusOK, "form_post.html", map[string]interface{}{"action": redirectURI, "params": params})
+	default:
+		q := u.Query()
+		for k, vs := range params {
+			for _, v := range vs {
+				q.Add(k, v)
+			}
+		}
+		u.RawQuery = q.Encode()
+		return c.Redirect(http.StatusSeeOther, u.String())
+	}
+}
+
+func (h *Handler) sendAuthorizationError(c echo.Context, client *model.Client, redirectURI, responseMode, state, code string) error {
+	params := url.Values{"error": {code}}
+	if state != "" {
+		params.Set("state", state)
+	}
+	return h.sendAuthorizationResponse(c, client, redirectURI, responseMode, params)
+}
+
+// signAuthorizationResponse signs the parameters of an authorization response
+// as the claims of a JWT for the client (JARM section 2.1).
+func (h *Handler) signAuthorizationResponse(client *model.Client, params url.Values) (string, error) {
+	claims := make(map[string]interface{}, len(params)+3)
+	for k := range params {
+		claims[k] = params.Get(k)
+	}
+	claims["iss"] = h.issuer
+	claims["aud"] = client.Name
+	claims["exp"] = jose.NewNumericDate(time.Now().Add(authResponseTTL))
+	return h.keys.sign(claims, "JWT")
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/voice0726/oauth-playground/model"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+func (h *Handler) HandleRevoke(c echo.Context) error {
+	client, err := h.authenticateClientRequest(c)
+	if err != nil {
+		if errors.Is(err, errCredentialsInQuery) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": err.Error()})
+		}
+		if isClientAuthenticationError(err) {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		}
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+
+	token := c.FormValue("token")
+	if token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "token required"})
+	}
+
+	// the hint only decides which kind of token is looked up first
+	var found bool
+	if c.FormValue("token_type_hint") == "refresh_token" {
+		found, err = h.revokeRefreshToken(client, token)
+		if err == nil && !found {
+			found, err = h.revokeAccessToken(client, token)
+		}
+	} else {
+		found, err = h.revokeAccessToken(client, token)
+		if err == nil && !found {
+			found, err = h.revokeRefreshToken(client, token)
+		}
+	}
+	if err != nil {
+		h.logger.Error("failed to revoke token", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+	if !found {
+		h.logger.Info("token to revoke not found")
+	}
+
+	// RFC 7009 section 2.2: unknown tokens are answered with 200 as well
+	return c.NoContent(http.StatusOK)
+}
+
+func (h *Handler) revokeAccessToken(client *model.Client, token string) (bool, error) {
+	t, err := h.tokenRepository.FindByToken(token)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if t.ClientID != client.ID {
+		h.logger.Info("refusing to revoke a token issued to another client", zap.String("client", client.ID.String()))
+		return true, nil
+	}
+
+	return true, h.tokenRepository.Revoke(t.ID)
+}
+
+// revokeRefreshToken also revokes the access tokens issued from the same grant,
+// as RFC 7009 section 2.1 recommends.
+func (h *Handler) revokeRefreshToken(client *model.Client, token string) (bool, error) {
+	t, err := h.refreshTokenRepository.FindByToken(token)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if t.ClientID != client.ID {
+		h.logger.Info("refusing to revoke a token issued to another client", zap.String("client", client.ID.String()))
+		return true, nil
+	}
+
+	return true, h.revokeFamily(t.FamilyID)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/voice0726/oauth-playground/model"
+)
+
+func TestHandleRevoke(t *testing.T) {
+	s := newTestServer(t)
+	client := s.createClient(t, model.Client{})
+	otherClient := s.createClient(t, model.Client{})
+
+	revoke := func(c *model.Client, token, hint string) {
+		t.Helper()
+		form := url.Values{"token": {token}}
+		if hint != "" {
+			form.Set("token_type_hint", hint)
+		}
+		// RFC 7009 section 2.2: 200 whether or not anything was revoked
+		if rec := s.postForm("/revoke", form, c.Name, c.Secret); rec.Code != http.StatusOK {
+			t.Fatalf("revoke status = %d: %s", rec.Code, rec.Body)
+		}
+	}
+	active := func(token string) bool {
+		t.Helper()
+		at, err := s.h.tokenRepository.FindByToken(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return isAccessTokenActive(at)
+	}
+
+	t.Run("access token", func(t *testing.T) {
+		res := s.redeemCode(t, client, s.issueCode(t, client, "user", "profile"), nil)
+		revoke(otherClient, res.AccessToken, "")
+		if !active(res.AccessToken) {
+			t.Fatal("another client revoked the token")
+		}
+		revoke(client, res.AccessToken, "refresh_token")
+		if active(res.AccessToken) {
+			t.Error("access token is still active")
+		}
+		// the refresh token is a separate grant artifact and survives
+		decodeTokenResponse(t, s.tokenRequest(t, client, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {res.RefreshToken}}, nil))
+	})
+
+	t.Run("refresh token", func(t *testing.T) {
+		res := s.redeemCode(t, client, s.issueCode(t, client, "user", "profile"), nil)
+		revoke(client, res.RefreshToken, "")
+		if active(res.AccessToken) {
+			t.Error("access token of the revoked refresh token is still active")
+		}
+		rec := s.tokenRequest(t, client, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {res.RefreshToken}}, nil)
+		if tokenError(t, rec) != "invalid_grant" {
+			t.Errorf("refresh after revoke: %s", rec.Body)
+		}
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		revoke(client, "unknown", "")
+	})
+
+	t.Run("token required", func(t *testing.T) {
+		if rec := s.postForm("/revoke", url.Values{}, client.Name, client.Secret); tokenError(t, rec) != "invalid_request" {
+			t.Errorf("status = %d: %s", rec.Code, rec.Body)
+		}
+	})
+}
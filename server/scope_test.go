@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/voice0726/oauth-playground/model"
+)
+
+func TestNarrowScope(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested string
+		granted   string
+		want      string
+		wantErr   bool
+	}{
+		{name: "nothing requested", granted: "openid profile", want: "openid profile"},
+		{name: "same scope", requested: "openid profile", granted: "openid profile", want: "openid profile"},
+		{name: "narrower", requested: "profile", granted: "openid profile", want: "profile"},
+		{name: "another order", requested: "profile openid", granted: "openid profile", want: "profile openid"},
+		{name: "wider", requested: "openid profile email", granted: "openid profile", wantErr: true},
+		{name: "nothing granted", requested: "profile", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := narrowScope(tt.requested, tt.granted)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("narrowScope() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("narrowScope() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefreshNarrowsScope(t *testing.T) {
+	s := newTestServer(t)
+	client := s.createClient(t, model.Client{})
+	res := s.redeemCode(t, client, s.issueCode(t, client, "user", "openid profile email"), nil)
+
+	refresh := func(token, scope string) url.Values {
+		form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {token}}
+		if scope != "" {
+			form.Set("scope", scope)
+		}
+		return form
+	}
+
+	if rec := s.tokenRequest(t, client, refresh(res.RefreshToken, "openid profile email read"), nil); tokenError(t, rec) != "invalid_scope" {
+		t.Fatalf("widening refresh: %s", rec.Body)
+	}
+
+	narrowed := decodeTokenResponse(t, s.tokenRequest(t, client, refresh(res.RefreshToken, "profile"), nil))
+	if narrowed.Scope != "profile" {
+		t.Errorf("narrowed scope = %q, want %q", narrowed.Scope, "profile")
+	}
+
+	// the refresh token keeps the whole grant, so a later refresh can get it
+	// back
+	full := decodeTokenResponse(t, s.tokenRequest(t, client, refresh(narrowed.RefreshToken, ""), nil))
+	if full.Scope != "openid profile email" {
+		t.Errorf("scope = %q, want %q", full.Scope, "openid profile email")
+	}
+}
@@ -1,9 +1,11 @@
 package server
 
 import (
+	"crypto/tls"
 	"html/template"
 	"io"
 	"log"
+	"net/http"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -16,7 +18,9 @@ type Server struct {
 	logger *zap.Logger
 }
 
-func NewServer(logger *zap.Logger) (*Server, error) {
+// NewServer creates the authorization server. mtls may be nil if StartTLS is
+// not going to be used, and policies if no client has one.
+func NewServer(issuer string, mtls *MTLSConfig, policies map[string]ClientPolicy, logger *zap.Logger) (*Server, error) {
 	e := echo.New()
 	e.Renderer = &Template{
 		templates: template.Must(template.ParseGlob("server/templates/*.html")),
@@ -40,8 +44,34 @@ func NewServer(logger *zap.Logger) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
+	refreshTokenRepo, err := repository.NewRefreshTokenRepository(dsn, logger)
+	if err != nil {
+		return nil, err
+	}
+	deviceCodeRepo, err := repository.NewDeviceCodeRepository(dsn, logger)
+	if err != nil {
+		return nil, err
+	}
 
-	h, err := NewHandler(clientRepo, authReqRepo, codeRepo, tokenRepo, logger)
+	jtiRepo, err := repository.NewJTIRepository(dsn, logger)
+	if err != nil {
+		return nil, err
+	}
+	userRepo, err := repository.NewUserRepository(dsn, logger)
+	if err != nil {
+		return nil, err
+	}
+	sessionRepo, err := repository.NewSessionRepository(dsn, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	consentRepo, err := repository.NewConsentRepository(dsn, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := NewHandler(clientRepo, authReqRepo, codeRepo, tokenRepo, refreshTokenRepo, deviceCodeRepo, jtiRepo, userRepo, sessionRepo, consentRepo, issuer, mtls, policies, logger)
 
 	if err != nil {
 		return nil, err
@@ -67,9 +97,32 @@ func NewServer(logger *zap.Logger) (*Server, error) {
 
 func initializeRoutes(e *echo.Echo, h Handler) {
 	e.GET("/", h.HandleIndex)
+	e.GET("/.well-known/jwks.json", h.HandleJWKS)
+	e.GET("/.well-known/oauth-authorization-server", h.HandleAuthorizationServerMetadata)
+	e.GET("/.well-known/openid-configuration", h.HandleOpenIDConfiguration)
 	e.GET("/authorize", h.HandleAuthorize)
+	e.POST("/par", h.HandlePushedAuthorizationRequest)
 	e.POST("/approve", h.HandleApprove)
+	e.GET("/login", h.HandleLoginPage)
+	e.POST("/login", h.HandleLogin)
+	e.GET("/signup", h.HandleSignupPage)
+	e.POST("/signup", h.HandleSignup)
+	e.POST("/logout", h.HandleLogout)
+	e.GET("/grants", h.HandleGrants)
+	e.POST("/grants/revoke", h.HandleRevokeGrant)
 	e.POST("/token", h.HandleToken)
+	e.POST("/introspect", h.HandleIntrospect)
+	e.POST("/revoke", h.HandleRevoke)
+	e.GET("/userinfo", h.HandleUserinfo)
+	e.POST("/userinfo", h.HandleUserinfo)
+	e.POST("/register", h.HandleRegister)
+	e.GET("/register/:client_id", h.HandleGetRegistration)
+	e.PUT("/register/:client_id", h.HandleUpdateRegistration)
+	e.DELETE("/register/:client_id", h.HandleDeleteRegistration)
+	e.POST("/device_authorization", h.HandleDeviceAuthorization)
+	e.GET("/device", h.HandleDevice)
+	e.POST("/device", h.HandleDeviceVerify)
+	e.POST("/device/approve", h.HandleDeviceApprove)
 }
 
 type Template struct {
@@ -87,3 +140,21 @@ func (t *Template) Render(w io.Writer, name string, data interface{}, c echo.Con
 func (s *Server) Start(address string) error {
 	return s.e.Start(address)
 }
+
+// StartTLS serves the same routes over TLS with a self-signed certificate,
+// requesting but not requiring a client certificate so that clients can
+// authenticate with mutual TLS (RFC 8705).
+func (s *Server) StartTLS(address string) error {
+	cert, err := selfSignedCertificate("localhost", "127.0.0.1")
+	if err != nil {
+		return err
+	}
+	return s.e.StartServer(&http.Server{
+		Addr: address,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.RequestClientCert,
+			MinVersion:   tls.VersionTLS12,
+		},
+	})
+}
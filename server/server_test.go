@@ -0,0 +1,171 @@
+package server
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/voice0726/oauth-playground/model"
+	"github.com/voice0726/oauth-playground/repository"
+	"go.step.sm/crypto/randutil"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const testIssuer = "http://as.example"
+
+// testServer serves the routes of a Handler backed by a fresh database.
+type testServer struct {
+	h *Handler
+	e *echo.Echo
+}
+
+// newTestServer creates the server, applying opts to the handler before its
+// routes are registered.
+func newTestServer(t *testing.T, opts ...func(h *Handler)) *testServer {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "test.db")
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&model.AuthCode{}, &model.Client{}, &model.AuthRequest{}, &model.Token{}, &model.RefreshToken{}, &model.DeviceCode{}, &model.User{}, &model.Session{}, &model.Consent{}, &model.JTI{}); err != nil {
+		t.Fatal(err)
+	}
+
+	lg := zap.NewNop()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	clientRepo, err := repository.NewClientRepository(dsn, lg)
+	must(err)
+	authReqRepo, err := repository.NewAuthRequestRepository(dsn, lg)
+	must(err)
+	codeRepo, err := repository.NewCodeRepository(dsn, lg)
+	must(err)
+	tokenRepo, err := repository.NewTokenRepository(dsn, lg)
+	must(err)
+	refreshTokenRepo, err := repository.NewRefreshTokenRepository(dsn, lg)
+	must(err)
+	deviceCodeRepo, err := repository.NewDeviceCodeRepository(dsn, lg)
+	must(err)
+	jtiRepo, err := repository.NewJTIRepository(dsn, lg)
+	must(err)
+	userRepo, err := repository.NewUserRepository(dsn, lg)
+	must(err)
+	sessionRepo, err := repository.NewSessionRepository(dsn, lg)
+	must(err)
+	consentRepo, err := repository.NewConsentRepository(dsn, lg)
+	must(err)
+
+	h, err := NewHandler(clientRepo, authReqRepo, codeRepo, tokenRepo, refreshTokenRepo, deviceCodeRepo, jtiRepo, userRepo, sessionRepo, consentRepo, testIssuer, nil, nil, lg)
+	must(err)
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	e := echo.New()
+	e.Renderer = &Template{templates: template.Must(template.ParseGlob("templates/*.html"))}
+	initializeRoutes(e, *h)
+	return &testServer{h: h, e: e}
+}
+
+func (s *testServer) do(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.e.ServeHTTP(rec, req)
+	return rec
+}
+
+// postForm sends form to path, authenticating as clientID with secret when
+// clientID is not empty.
+func (s *testServer) postForm(path string, form url.Values, clientID, secret string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	if clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(secret))
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	return s.do(req)
+}
+
+func (s *testServer) get(path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	return s.do(req)
+}
+
+// createClient saves client, defaulting to a confidential client with a
+// secret and a single redirect URI.
+func (s *testServer) createClient(t *testing.T, client model.Client) *model.Client {
+	t.Helper()
+	if client.Name == "" {
+		client.Name = "client-" + randomString(t, 8)
+	}
+	if client.Secret == "" && client.TokenEndpointAuthMethod == "" {
+		client.Secret = "secret"
+	}
+	if len(client.RedirectURIs) == 0 {
+		client.RedirectURIs = []string{"https://client.example/callback"}
+	}
+	created, err := s.h.clientRepository.Create(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return created
+}
+
+// login creates a user and a session for it, returning the session cookie.
+func (s *testServer) login(t *testing.T) (*model.User, *http.Cookie) {
+	t.Helper()
+	user, err := s.h.userRepository.Create(model.User{Username: "user-" + randomString(t, 8)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := randomString(t, 48)
+	_, err = s.h.sessionRepository.Create(model.Session{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		AuthTime:  time.Now(),
+		ExpiresAt: time.Now().Add(sessionTTL),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, &http.Cookie{Name: sessionCookieName, Value: token}
+}
+
+func randomString(t *testing.T, n int) string {
+	t.Helper()
+	s, err := randutil.Alphanumeric(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// redirectParams returns the query parameters of the redirect in rec.
+func redirectParams(t *testing.T, rec *httptest.ResponseRecorder) url.Values {
+	t.Helper()
+	if rec.Code != http.StatusFound && rec.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d, want a redirect: %s", rec.Code, rec.Body)
+	}
+	loc, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc.Query()
+}
@@ -0,0 +1,217 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/voice0726/oauth-playground/model"
+	"go.step.sm/crypto/randutil"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	// the client app is served from localhost as well and cookies are not
+	// scoped by port, so the name must not clash with the client's cookies
+	sessionCookieName = "as_session"
+	sessionTTL        = 12 * time.Hour
+	minPasswordLength = 8
+)
+
+var errNoSession = errors.New("no session")
+
+// currentSession returns the user signed in to the authorization server, or
+// errNoSession when the browser has no valid session.
+func (h *Handler) currentSession(c echo.Context) (*model.Session, *model.User, error) {
+	cookie, err := c.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, nil, errNoSession
+	}
+
+	sess, err := h.sessionRepository.FindByTokenHash(hashToken(cookie.Value))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, errNoSession
+		}
+		return nil, nil, err
+	}
+	if time.Now().After(sess.ExpiresAt) {
+		return nil, nil, errNoSession
+	}
+
+	user, err := h.userRepository.FindByID(sess.UserID.String())
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, errNoSession
+		}
+		return nil, nil, err
+	}
+
+	return sess, user, nil
+}
+
+func (h *Handler) startSession(c echo.Context, user *model.User) error {
+	token, err := randutil.Alphanumeric(48)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	sess, err := h.sessionRepository.Create(model.Session{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		AuthTime:  now,
+		ExpiresAt: now.Add(sessionTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  sess.ExpiresAt,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// redirectToLogin sends the browser to the login page and back to the current
+// request once the user has signed in.
+func redirectToLogin(c echo.Context) error {
+	return c.Redirect(http.StatusSeeOther, "/login?return_to="+url.QueryEscape(c.Request().URL.RequestURI()))
+}
+
+// safeReturnTo only lets the login page redirect within this server.
+func safeReturnTo(returnTo string) string {
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.HasPrefix(returnTo, "/\\") {
+		return "/"
+	}
+	return returnTo
+}
+
+func (h *Handler) HandleLoginPage(c echo.Context) error {
+	return c.Render(http.StatusOK, "login.html", map[string]string{"return_to": safeReturnTo(c.QueryParam("return_to"))})
+}
+
+func (h *Handler) HandleLogin(c echo.Context) error {
+	var b struct {
+		Username string `form:"username"`
+		Password string `form:"password"`
+		ReturnTo string `form:"return_to"`
+	}
+	if err := (&echo.DefaultBinder{}).BindBody(c, &b); err != nil {
+		h.logger.Error("failed to parse request body", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+	returnTo := safeReturnTo(b.ReturnTo)
+
+	user, err := h.userRepository.FindByUsername(b.Username)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		h.logger.Error("failed to get user", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+	if err != nil || bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(b.Password)) != nil {
+		return c.Render(http.StatusUnauthorized, "login.html", map[string]string{"error": "invalid username or password", "username": b.Username, "return_to": returnTo})
+	}
+
+	if err := h.startSession(c, user); err != nil {
+		h.logger.Error("failed to start session", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+
+	return c.Redirect(http.StatusSeeOther, returnTo)
+}
+
+func (h *Handler) HandleSignupPage(c echo.Context) error {
+	return c.Render(http.StatusOK, "signup.html", map[string]string{"return_to": safeReturnTo(c.QueryParam("return_to"))})
+}
+
+func (h *Handler) HandleSignup(c echo.Context) error {
+	var b struct {
+		Username   string `form:"username"`
+		Password   string `form:"password"`
+		GivenName  string `form:"given_name"`
+		FamilyName string `form:"family_name"`
+		Email      string `form:"email"`
+		ReturnTo   string `form:"return_to"`
+	}
+	if err := (&echo.DefaultBinder{}).BindBody(c, &b); err != nil {
+		h.logger.Error("failed to parse request body", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+	data := map[string]string{"username": b.Username, "given_name": b.GivenName, "family_name": b.FamilyName, "email": b.Email, "return_to": safeReturnTo(b.ReturnTo)}
+
+	if b.Username == "" {
+		data["error"] = "username required"
+		return c.Render(http.StatusBadRequest, "signup.html", data)
+	}
+	if len(b.Password) < minPasswordLength {
+		data["error"] = "password must be at least 8 characters"
+		return c.Render(http.StatusBadRequest, "signup.html", data)
+	}
+
+	_, err := h.userRepository.FindByUsername(b.Username)
+	if err == nil {
+		data["error"] = "username is already taken"
+		return c.Render(http.StatusBadRequest, "signup.html", data)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		h.logger.Error("failed to get user", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(b.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+	user, err := h.userRepository.Create(model.User{
+		Username:     b.Username,
+		PasswordHash: string(hash),
+		Name:         strings.TrimSpace(b.GivenName + " " + b.FamilyName),
+		GivenName:    b.GivenName,
+		FamilyName:   b.FamilyName,
+		Email:        b.Email,
+	})
+	if err != nil {
+		h.logger.Error("failed to create user", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+
+	if err := h.startSession(c, user); err != nil {
+		h.logger.Error("failed to start session", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+
+	return c.Redirect(http.StatusSeeOther, data["return_to"])
+}
+
+func (h *Handler) HandleLogout(c echo.Context) error {
+	sess, _, err := h.currentSession(c)
+	if err != nil && !errors.Is(err, errNoSession) {
+		h.logger.Error("failed to get session", zap.Error(err))
+		return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+	}
+	if sess != nil {
+		if err := h.sessionRepository.Delete(sess.ID); err != nil {
+			h.logger.Error("failed to delete session", zap.Error(err))
+			return c.Render(http.StatusInternalServerError, "error.html", map[string]string{"error": "internal server error"})
+		}
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.Redirect(http.StatusSeeOther, "/login")
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/voice0726/oauth-playground/model"
+	"go.step.sm/crypto/randutil"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	accessTokenTTL  = time.Hour
+	refreshTokenTTL = 30 * 24 * time.Hour
+)
+
+type tokenResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+	RefreshToken    string `json:"refresh_token,omitempty"`
+	IDToken         string `json:"id_token,omitempty"`
+	Scope           string `json:"scope"`
+}
+
+func (h *Handler) issueAccessToken(client *model.Client, subject, scope string, familyID uuid.UUID, cnf *confirmation) (*model.Token, error) {
+	t := model.Token{
+		Subject:   subject,
+		Scope:     scope,
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().Add(accessTokenTTL),
+	}
+	if cnf != nil {
+		t.JKT = cnf.JKT
+		t.X5TS256 = cnf.X5TS256
+	}
+	return h.createAccessToken(client, t)
+}
+
+// createAccessToken fills in the token value of t, in the format the client
+// registered, and stores it.
+func (h *Handler) createAccessToken(client *model.Client, t model.Token) (*model.Token, error) {
+	t.ClientID = client.ID
+
+	var err error
+	if client.AccessTokenFormat == model.AccessTokenFormatJWT {
+		t.Token, err = h.signAccessToken(client, &t, time.Now())
+	} else {
+		t.Token, err = randutil.Alphanumeric(32)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return h.tokenRepository.Create(t)
+}
+
+func (h *Handler) issueRefreshToken(client *model.Client, subject, scope string, familyID uuid.UUID, cnf *confirmation) (*model.RefreshToken, error) {
+	token, err := randutil.Alphanumeric(48)
+	if err != nil {
+		return nil, err
+	}
+
+	rt := model.RefreshToken{
+		Token:     token,
+		ClientID:  client.ID,
+		Subject:   subject,
+		Scope:     scope,
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	}
+	// confidential clients already have to authenticate to use a refresh
+	// token, so only the ones of public clients are bound (RFC 9449 section 5)
+	if cnf != nil && clientAuthMethod(client) == authMethodNone {
+		rt.JKT = cnf.JKT
+	}
+	return h.refreshTokenRepository.Create(rt)
+}
+
+// issueTokens issues an access token for scope together with a refresh token
+// that keeps the full grantedScope, both belonging to the same token family.
+// The access token is bound to cnf, if any, and so is the refresh token of a
+// public client.
+func (h *Handler) issueTokens(client *model.Client, subject, scope, grantedScope string, familyID uuid.UUID, cnf *confirmation) (*tokenResponse, error) {
+	at, err := h.issueAccessToken(client, subject, scope, familyID, cnf)
+	if err != nil {
+		return nil, err
+	}
+	rt, err := h.issueRefreshToken(client, subject, grantedScope, familyID, cnf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenResponse{
+		AccessToken:  at.Token,
+		TokenType:    tokenType(at),
+		ExpiresIn:    int64(accessTokenTTL.Seconds()),
+		RefreshToken: rt.Token,
+		Scope:        at.Scope,
+	}, nil
+}
+
+func (h *Handler) handleRefreshTokenGrant(c echo.Context, client *model.Client, body *tokenRequest) error {
+	if body.RefreshToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "refresh token required"})
+	}
+
+	rt, err := h.refreshTokenRepository.FindByToken(body.RefreshToken)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			h.logger.Info("refresh token not found")
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		}
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+
+	if rt.ClientID != client.ID {
+		h.logger.Info("refresh token was issued to another client", zap.String("expected", rt.ClientID.String()), zap.String("got", client.ID.String()))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+	}
+
+	if !isRefreshTokenUsable(rt) {
+		h.logger.Info("refresh token is revoked or expired", zap.String("family", rt.FamilyID.String()))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+	}
+
+	scope, err := narrowScope(body.Scope, rt.Scope)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_scope"})
+	}
+
+	// a bound refresh token can only be used with a proof for its key, not
+	// just for any key (RFC 9449 section 5)
+	if rt.JKT != "" && (body.cnf == nil || body.cnf.JKT != rt.JKT) {
+		h.logger.Info("refresh token is bound to a DPoP key that the request does not prove", zap.String("family", rt.FamilyID.String()))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "DPoP proof for the bound key required"})
+	}
+
+	rotated := false
+	if !rt.Rotated {
+		rotated, err = h.refreshTokenRepository.MarkRotated(rt.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "internal server error")
+		}
+	}
+	if !rotated {
+		h.logger.Warn("refresh token reuse detected, revoking token family", zap.String("family", rt.FamilyID.String()))
+		if err := h.revokeFamily(rt.FamilyID); err != nil {
+			h.logger.Error("failed to revoke token family", zap.Error(err))
+			return c.JSON(http.StatusInternalServerError, "internal server error")
+		}
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+	}
+
+	res, err := h.issueTokens(client, rt.Subject, scope, rt.Scope, rt.FamilyID, body.cnf)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) handleClientCredentialsGrant(c echo.Context, client *model.Client, body *tokenRequest) error {
+	if err := validateScope(client, body.Scope); err != nil {
+		h.logger.Info("requested scope is not allowed for the client", zap.String("scope", body.Scope))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_scope"})
+	}
+	// the client acts on its own behalf, so there is no user for the OpenID
+	// Connect scopes to be about
+	if slices.ContainsFunc(strings.Fields(body.Scope), isUserScope) {
+		h.logger.Info("user scope requested with client credentials", zap.String("scope", body.Scope))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_scope"})
+	}
+	apiScopes := slices.DeleteFunc(slices.Clone(allowedScopes(client)), isUserScope)
+	scope, _ := narrowScope(body.Scope, strings.Join(apiScopes, " "))
+
+	// no user is involved, so the token family is just this one access token
+	at, err := h.issueAccessToken(client, "", scope, uuid.New(), body.cnf)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+
+	return c.JSON(http.StatusOK, &tokenResponse{
+		AccessToken: at.Token,
+		TokenType:   tokenType(at),
+		ExpiresIn:   int64(accessTokenTTL.Seconds()),
+		Scope:       at.Scope,
+	})
+}
+
+// revokeFamily revokes every access and refresh token that descends from the
+// same original grant.
+func (h *Handler) revokeFamily(familyID uuid.UUID) error {
+	if err := h.refreshTokenRepository.RevokeFamily(familyID); err != nil {
+		return err
+	}
+	return h.tokenRepository.RevokeFamily(familyID)
+}
+
+func isAccessTokenActive(t *model.Token) bool {
+	return !t.Revoked && time.Now().Before(t.ExpiresAt)
+}
+
+// isRefreshTokenUsable deliberately ignores Rotated so that the refresh grant
+// can still see a replayed token and revoke its family.
+func isRefreshTokenUsable(t *model.RefreshToken) bool {
+	return !t.Revoked && time.Now().Before(t.ExpiresAt)
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/voice0726/oauth-playground/model"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	grantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+	// RFC 8693 section 3
+	tokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+var errInvalidExchangeToken = errors.New("token is not an active access token")
+
+// actor is the act claim of a delegated token (RFC 8693 section 4.1). Prior
+// actors in a delegation chain are nested in Act.
+type actor struct {
+	Sub string `json:"sub"`
+	Act *actor `json:"act,omitempty"`
+}
+
+func tokenActor(t *model.Token) *actor {
+	if t.Actor == "" {
+		return nil
+	}
+	var act actor
+	// stored by handleTokenExchangeGrant, so it is known to be valid
+	_ = json.Unmarshal([]byte(t.Actor), &act)
+	return &act
+}
+
+// handleTokenExchangeGrant issues an access token for the subject of another
+// access token (RFC 8693). With an actor token the client acts on behalf of
+// the subject and the new token records that in its act claim; without one
+// the client impersonates the subject. Which tokens a client may exchange,
+// for which audiences and whether it may impersonate are set by the operator
+// in the client's policy.
+func (h *Handler) handleTokenExchangeGrant(c echo.Context, client *model.Client, body *tokenRequest) error {
+	policy := h.clientPolicy(client).TokenExchange
+	if body.SubjectToken == "" || body.SubjectTokenType == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "subject_token and subject_token_type required"})
+	}
+	if body.SubjectTokenType != tokenTypeAccessToken {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "unsupported subject_token_type"})
+	}
+	if body.RequestedTokenType != "" && body.RequestedTokenType != tokenTypeAccessToken {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "unsupported requested_token_type"})
+	}
+
+	subjectToken, subjectClient, err := h.findExchangeableToken(body.SubjectToken)
+	if err != nil {
+		if errors.Is(err, errInvalidExchangeToken) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "invalid subject_token"})
+		}
+		h.logger.Error("failed to find subject token", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+	if !provesPossession(c, subjectToken, body.cnf) {
+		h.logger.Info("subject token is bound to a key that the request does not prove")
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "proof of possession for the subject_token required"})
+	}
+	// a client may always exchange its own tokens
+	if subjectClient.ID != client.ID && !slices.Contains(policy.SubjectClients, subjectClient.Name) {
+		h.logger.Info("client may not exchange the subject token", zap.String("subject_client", subjectClient.Name))
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "unauthorized_client", "error_description": "the client may not exchange tokens issued to " + subjectClient.Name})
+	}
+
+	targets := append(slices.Clone(body.Audience), body.Resource...)
+	for _, target := range targets {
+		if !slices.Contains(policy.Audiences, target) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_target", "error_description": "the client may not request tokens for " + target})
+		}
+	}
+
+	// the exchanged token can be narrower than the subject token, never wider
+	scope, err := narrowScope(body.Scope, subjectToken.Scope)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_scope"})
+	}
+	if err := validateScope(client, scope); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_scope", "error_description": err.Error()})
+	}
+
+	var act *actor
+	if body.ActorToken != "" {
+		if body.ActorTokenType != tokenTypeAccessToken {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "unsupported actor_token_type"})
+		}
+		actorToken, actorClient, err := h.findExchangeableToken(body.ActorToken)
+		if err != nil {
+			if errors.Is(err, errInvalidExchangeToken) {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "invalid actor_token"})
+			}
+			h.logger.Error("failed to find actor token", zap.Error(err))
+			return c.JSON(http.StatusInternalServerError, "internal server error")
+		}
+		if !provesPossession(c, actorToken, body.cnf) {
+			h.logger.Info("actor token is bound to a key that the request does not prove")
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "proof of possession for the actor_token required"})
+		}
+		// the client can only delegate to itself, not speak for others
+		if actorClient.ID != client.ID {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "actor_token must be issued to the client"})
+		}
+		act = &actor{Sub: tokenSubject(actorToken, actorClient), Act: tokenActor(subjectToken)}
+	} else {
+		if !policy.AllowImpersonation {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "unauthorized_client", "error_description": "the client may not impersonate; an actor_token is required"})
+		}
+		// delegation that already happened stays visible
+		act = tokenActor(subjectToken)
+	}
+
+	// the exchanged token joins the family of the subject token, so that
+	// revoking the grant behind it revokes the exchanged token too, and does
+	// not outlive it
+	t := model.Token{
+		Subject:   tokenSubject(subjectToken, subjectClient),
+		Scope:     scope,
+		FamilyID:  subjectToken.FamilyID,
+		Audience:  targets,
+		ExpiresAt: time.Now().Add(accessTokenTTL),
+	}
+	if subjectToken.ExpiresAt.Before(t.ExpiresAt) {
+		t.ExpiresAt = subjectToken.ExpiresAt
+	}
+	if act != nil {
+		b, err := json.Marshal(act)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, "internal server error")
+		}
+		t.Actor = string(b)
+	}
+	if body.cnf != nil {
+		t.JKT = body.cnf.JKT
+		t.X5TS256 = body.cnf.X5TS256
+	}
+
+	at, err := h.createAccessToken(client, t)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, "internal server error")
+	}
+
+	return c.JSON(http.StatusOK, &tokenResponse{
+		AccessToken:     at.Token,
+		IssuedTokenType: tokenTypeAccessToken,
+		TokenType:       tokenType(at),
+		ExpiresIn:       int64(time.Until(at.ExpiresAt).Seconds()),
+		Scope:           at.Scope,
+	})
+}
+
+// findExchangeableToken returns the active access token presented as a
+// subject or actor token, along with the client it was issued to.
+func (h *Handler) findExchangeableToken(token string) (*model.Token, *model.Client, error) {
+	t, err := h.tokenRepository.FindByToken(token)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, errInvalidExchangeToken
+		}
+		return nil, nil, err
+	}
+	if !isAccessTokenActive(t) {
+		return nil, nil, errInvalidExchangeToken
+	}
+	client, err := h.clientRepository.FindClientByID(t.ClientID.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	return t, client, nil
+}
+
+// provesPossession reports whether the request proves possession of the DPoP
+// key or the certificate t is bound to, as presenting t to a resource would
+// have to. Otherwise a stolen bound token could be exchanged for a bearer one.
+func provesPossession(c echo.Context, t *model.Token, cnf *confirmation) bool {
+	if t.JKT != "" && (cnf == nil || cnf.JKT != t.JKT) {
+		return false
+	}
+	if t.X5TS256 != "" {
+		cert := clientCertificate(c)
+		if cert == nil || certificateThumbprint(cert) != t.X5TS256 {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenSubject returns who t is about: its resource owner, or the client it
+// was issued to for tokens without one.
+func tokenSubject(t *model.Token, client *model.Client) string {
+	if t.Subject == "" {
+		return client.Name
+	}
+	return t.Subject
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/voice0726/oauth-playground/model"
+	"go.step.sm/crypto/jose"
+)
+
+func TestTokenExchangeGrant(t *testing.T) {
+	s := newTestServer(t, func(h *Handler) {
+		h.policies = map[string]ClientPolicy{
+			"api": {TokenExchange: TokenExchangePolicy{
+				SubjectClients: []string{"frontend"},
+				Audiences:      []string{"https://backend.example"},
+			}},
+			"impersonator": {TokenExchange: TokenExchangePolicy{
+				SubjectClients:     []string{"frontend"},
+				AllowImpersonation: true,
+			}},
+		}
+	})
+	frontend := s.createClient(t, model.Client{Name: "frontend"})
+	api := s.createClient(t, model.Client{Name: "api"})
+	impersonator := s.createClient(t, model.Client{Name: "impersonator"})
+	unrelated := s.createClient(t, model.Client{Name: "unrelated"})
+
+	key, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subjectToken := s.redeemCode(t, frontend, s.issueCode(t, frontend, "user", "openid profile email"), nil).AccessToken
+	boundSubjectToken := s.redeemCode(t, frontend, s.issueCode(t, frontend, "user", "profile"), key).AccessToken
+	revokedSubjectToken := s.redeemCode(t, frontend, s.issueCode(t, frontend, "user", "profile"), nil).AccessToken
+	revoked, err := s.h.tokenRepository.FindByToken(revokedSubjectToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.h.tokenRepository.Revoke(revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+	clientToken := func(client *model.Client) string {
+		return decodeTokenResponse(t, s.tokenRequest(t, client, url.Values{"grant_type": {"client_credentials"}}, nil)).AccessToken
+	}
+	apiActorToken := clientToken(api)
+	unrelatedActorToken := clientToken(unrelated)
+
+	exchange := func(client *model.Client, subjectToken, actorToken string, extra url.Values, key *jose.JSONWebKey) *httptest.ResponseRecorder {
+		form := url.Values{
+			"grant_type":         {grantTypeTokenExchange},
+			"subject_token":      {subjectToken},
+			"subject_token_type": {tokenTypeAccessToken},
+		}
+		if actorToken != "" {
+			form.Set("actor_token", actorToken)
+			form.Set("actor_token_type", tokenTypeAccessToken)
+		}
+		for k, v := range extra {
+			form[k] = v
+		}
+		return s.tokenRequest(t, client, form, key)
+	}
+
+	t.Run("delegation", func(t *testing.T) {
+		res := decodeTokenResponse(t, exchange(api, subjectToken, apiActorToken, nil, nil))
+		if res.Scope != "openid profile email" {
+			t.Errorf("scope = %q, want the scope of the subject token", res.Scope)
+		}
+		token, err := s.h.tokenRepository.FindByToken(res.AccessToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.Subject != "user" {
+			t.Errorf("subject = %q, want %q", token.Subject, "user")
+		}
+		if act := tokenActor(token); act == nil || act.Sub != "api" {
+			t.Errorf("act = %+v, want the api client", act)
+		}
+	})
+
+	t.Run("impersonation", func(t *testing.T) {
+		res := decodeTokenResponse(t, exchange(impersonator, subjectToken, "", nil, nil))
+		token, err := s.h.tokenRepository.FindByToken(res.AccessToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.Subject != "user" || token.Actor != "" {
+			t.Errorf("subject = %q, actor = %q", token.Subject, token.Actor)
+		}
+	})
+
+	t.Run("narrowed scope", func(t *testing.T) {
+		res := decodeTokenResponse(t, exchange(api, subjectToken, apiActorToken, url.Values{"scope": {"profile"}}, nil))
+		if res.Scope != "profile" {
+			t.Errorf("scope = %q, want %q", res.Scope, "profile")
+		}
+	})
+
+	t.Run("allowed audience", func(t *testing.T) {
+		decodeTokenResponse(t, exchange(api, subjectToken, apiActorToken, url.Values{"audience": {"https://backend.example"}}, nil))
+	})
+
+	t.Run("bound subject token with its proof", func(t *testing.T) {
+		decodeTokenResponse(t, exchange(api, boundSubjectToken, apiActorToken, nil, key))
+	})
+
+	tests := []struct {
+		name         string
+		client       *model.Client
+		subjectToken string
+		actorToken   string
+		extra        url.Values
+		wantErr      string
+	}{
+		{name: "impersonation not allowed", client: api, subjectToken: subjectToken, wantErr: "unauthorized_client"},
+		{name: "subject client not allowed", client: unrelated, subjectToken: subjectToken, actorToken: unrelatedActorToken, wantErr: "unauthorized_client"},
+		{name: "actor token of another client", client: api, subjectToken: subjectToken, actorToken: unrelatedActorToken, wantErr: "invalid_request"},
+		{name: "audience not allowed", client: api, subjectToken: subjectToken, actorToken: apiActorToken, extra: url.Values{"audience": {"https://other.example"}}, wantErr: "invalid_target"},
+		{name: "resource not allowed", client: impersonator, subjectToken: subjectToken, extra: url.Values{"resource": {"https://backend.example"}}, wantErr: "invalid_target"},
+		{name: "wider scope", client: api, subjectToken: subjectToken, actorToken: apiActorToken, extra: url.Values{"scope": {"openid profile email read"}}, wantErr: "invalid_scope"},
+		{name: "revoked subject token", client: api, subjectToken: revokedSubjectToken, actorToken: apiActorToken, wantErr: "invalid_request"},
+		{name: "bound subject token without its proof", client: api, subjectToken: boundSubjectToken, actorToken: apiActorToken, wantErr: "invalid_request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := exchange(tt.client, tt.subjectToken, tt.actorToken, tt.extra, nil)
+			if got := tokenError(t, rec); got != tt.wantErr {
+				t.Errorf("error = %q, want %q: %s", got, tt.wantErr, rec.Body)
+			}
+		})
+	}
+}
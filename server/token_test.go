@@ -0,0 +1,204 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/voice0726/oauth-playground/dpop"
+	"github.com/voice0726/oauth-playground/model"
+	"go.step.sm/crypto/jose"
+)
+
+// issueCode saves an authorization code for subject, as if the user had
+// approved scope for client, applying opts before it is saved.
+func (s *testServer) issueCode(t *testing.T, client *model.Client, subject, scope string, opts ...func(code *model.AuthCode)) *model.AuthCode {
+	t.Helper()
+	code := model.AuthCode{
+		Code:        randomString(t, authCodeLength),
+		ClientID:    client.ID,
+		RedirectURI: client.RedirectURIs[0],
+		Subject:     subject,
+		Scope:       scope,
+		AuthTime:    time.Now(),
+		ExpiresAt:   time.Now().Add(authCodeTTL),
+	}
+	for _, opt := range opts {
+		opt(&code)
+	}
+	created, err := s.h.codeRepostiroy.Create(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return created
+}
+
+// tokenRequest posts form to the token endpoint as client, with the DPoP
+// proof of key if it is not nil.
+func (s *testServer) tokenRequest(t *testing.T, client *model.Client, form url.Values, key *jose.JSONWebKey) *httptest.ResponseRecorder {
+	t.Helper()
+	public := clientAuthMethod(client) == authMethodNone
+	if public {
+		form.Set("client_id", client.Name)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if !public {
+		req.SetBasicAuth(url.QueryEscape(client.Name), url.QueryEscape(client.Secret))
+	}
+	if key != nil {
+		proof, err := dpop.NewProof(key, http.MethodPost, testIssuer+"/token", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(dpop.HeaderName, proof)
+	}
+	return s.do(req)
+}
+
+// redeemCode exchanges code for tokens, failing the test if that does not
+// work.
+func (s *testServer) redeemCode(t *testing.T, client *model.Client, code *model.AuthCode, key *jose.JSONWebKey) *tokenResponse {
+	t.Helper()
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {code.Code}, "redirect_uri": {code.RedirectURI}}
+	return decodeTokenResponse(t, s.tokenRequest(t, client, form, key))
+}
+
+func decodeTokenResponse(t *testing.T, rec *httptest.ResponseRecorder) *tokenResponse {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("token endpoint status = %d: %s", rec.Code, rec.Body)
+	}
+	var res tokenResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	return &res
+}
+
+func tokenError(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var res map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("status = %d, unexpected body %s", rec.Code, rec.Body)
+	}
+	return res["error"]
+}
+
+func TestRefreshTokenDPoPBinding(t *testing.T) {
+	s := newTestServer(t)
+	key, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := jose.GenerateJWK("EC", "P-256", "ES256", "sig", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("confidential client", func(t *testing.T) {
+		client := s.createClient(t, model.Client{})
+		res := s.redeemCode(t, client, s.issueCode(t, client, "user", "profile"), key)
+		if res.TokenType != tokenTypeDPoP {
+			t.Errorf("token_type = %q, want %q", res.TokenType, tokenTypeDPoP)
+		}
+
+		// the client authenticates, so its refresh token is not bound
+		form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {res.RefreshToken}}
+		decodeTokenResponse(t, s.tokenRequest(t, client, form, nil))
+	})
+
+	t.Run("public client", func(t *testing.T) {
+		client := s.createClient(t, model.Client{TokenEndpointAuthMethod: authMethodNone})
+		res := s.redeemCode(t, client, s.issueCode(t, client, "user", "profile"), key)
+
+		form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {res.RefreshToken}}
+		if rec := s.tokenRequest(t, client, form, nil); tokenError(t, rec) != "invalid_grant" {
+			t.Errorf("refresh without a proof: %s", rec.Body)
+		}
+		if rec := s.tokenRequest(t, client, form, otherKey); tokenError(t, rec) != "invalid_grant" {
+			t.Errorf("refresh with a proof for another key: %s", rec.Body)
+		}
+		decodeTokenResponse(t, s.tokenRequest(t, client, form, key))
+	})
+}
+
+func TestClientCredentialsGrant(t *testing.T) {
+	s := newTestServer(t)
+	withScopes := s.createClient(t, model.Client{Scopes: []string{scopeOpenID, "profile", "read", "write"}})
+	withoutScopes := s.createClient(t, model.Client{})
+
+	tests := []struct {
+		name      string
+		client    *model.Client
+		scope     string
+		wantScope string
+		wantErr   string
+	}{
+		{name: "default", client: withScopes, wantScope: "read write"},
+		{name: "narrowed", client: withScopes, scope: "read", wantScope: "read"},
+		{name: "default without registered scopes", client: withoutScopes, wantScope: ""},
+		{name: "openid", client: withScopes, scope: "openid read", wantErr: "invalid_scope"},
+		{name: "profile", client: withScopes, scope: "profile", wantErr: "invalid_scope"},
+		{name: "not allowed", client: withoutScopes, scope: "read", wantErr: "invalid_scope"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"grant_type": {"client_credentials"}}
+			if tt.scope != "" {
+				form.Set("scope", tt.scope)
+			}
+			rec := s.tokenRequest(t, tt.client, form, nil)
+			if tt.wantErr != "" {
+				if got := tokenError(t, rec); got != tt.wantErr {
+					t.Fatalf("error = %q, want %q", got, tt.wantErr)
+				}
+				return
+			}
+			if res := decodeTokenResponse(t, rec); res.Scope != tt.wantScope {
+				t.Errorf("scope = %q, want %q", res.Scope, tt.wantScope)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	s := newTestServer(t)
+	client := s.createClient(t, model.Client{})
+	otherClient := s.createClient(t, model.Client{})
+
+	first := s.redeemCode(t, client, s.issueCode(t, client, "user", "profile"), nil)
+	refresh := func(c *model.Client, token string) *httptest.ResponseRecorder {
+		return s.tokenRequest(t, c, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {token}}, nil)
+	}
+
+	if rec := refresh(otherClient, first.RefreshToken); tokenError(t, rec) != "invalid_grant" {
+		t.Fatalf("refresh by another client: %s", rec.Body)
+	}
+
+	second := decodeTokenResponse(t, refresh(client, first.RefreshToken))
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("refresh token was not rotated")
+	}
+
+	// presenting the rotated token again revokes everything issued since
+	if rec := refresh(client, first.RefreshToken); tokenError(t, rec) != "invalid_grant" {
+		t.Fatalf("reuse: %s", rec.Body)
+	}
+	if rec := refresh(client, second.RefreshToken); tokenError(t, rec) != "invalid_grant" {
+		t.Errorf("refresh with the current token after reuse: %s", rec.Body)
+	}
+	for _, token := range []string{first.AccessToken, second.AccessToken} {
+		at, err := s.h.tokenRepository.FindByToken(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isAccessTokenActive(at) {
+			t.Error("access token is still active after reuse")
+		}
+	}
+}